@@ -0,0 +1,215 @@
+package persistence
+
+import (
+	"fmt"
+	"sync"
+)
+
+type changeState int
+
+const (
+	changeInserted changeState = iota
+	changeUpdated
+	changeDeleted
+)
+
+// pendingChange is the latest state of a changed item
+type pendingChange[T any] struct {
+	state changeState
+	id    any
+	item  T
+}
+
+// changeTracker collects changes of items in MemoryPersistence between saves.
+// Consecutive changes of the same item are merged, so only the latest state is saved.
+// When an item has no id, the changes can't be saved incrementally
+// and the tracker requests saving of all items.
+type changeTracker[T any] struct {
+	mtx     sync.Mutex
+	idOf    func(item T) any
+	keys    []string
+	changes map[string]*pendingChange[T]
+	full    bool
+}
+
+func newChangeTracker[T any](idOf func(item T) any) *changeTracker[T] {
+	return &changeTracker[T]{
+		idOf:    idOf,
+		changes: make(map[string]*pendingChange[T]),
+	}
+}
+
+func (c *changeTracker[T]) insert(item T) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	id := c.idOf(item)
+	if id == nil {
+		c.full = true
+		return
+	}
+	c.recordSet(id, item, changeInserted)
+}
+
+func (c *changeTracker[T]) update(item T) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	id := c.idOf(item)
+	if id == nil {
+		c.full = true
+		return
+	}
+	c.recordSet(id, item, changeUpdated)
+}
+
+func (c *changeTracker[T]) delete(item T) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	id := c.idOf(item)
+	if id == nil {
+		c.full = true
+		return
+	}
+	c.recordDelete(id)
+}
+
+func (c *changeTracker[T]) recordSet(id any, item T, state changeState) {
+	key := idKey(id)
+	change, ok := c.changes[key]
+	if !ok {
+		c.keys = append(c.keys, key)
+		c.changes[key] = &pendingChange[T]{state: state, id: id, item: item}
+		return
+	}
+
+	switch change.state {
+	case changeInserted:
+		// Item is still new for the data source
+	case changeDeleted:
+		// Item still exists in the data source
+		change.state = changeUpdated
+	default:
+		change.state = changeUpdated
+	}
+	change.item = item
+}
+
+func (c *changeTracker[T]) recordDelete(id any) {
+	key := idKey(id)
+	change, ok := c.changes[key]
+	if !ok {
+		c.keys = append(c.keys, key)
+		c.changes[key] = &pendingChange[T]{state: changeDeleted, id: id}
+		return
+	}
+
+	if change.state == changeInserted {
+		// Item never reached the data source
+		c.removeKey(key)
+		return
+	}
+
+	var empty T
+	change.state = changeDeleted
+	change.item = empty
+}
+
+func (c *changeTracker[T]) removeKey(key string) {
+	delete(c.changes, key)
+	for i, k := range c.keys {
+		if k == key {
+			c.keys = append(c.keys[:i], c.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// take returns collected changes and resets the tracker.
+//	Returns: collected changes and true when all items shall be saved instead.
+func (c *changeTracker[T]) take() (ChangeSet[T], bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	changes, full := c.collect()
+	c.resetUnsafe()
+	return changes, full
+}
+
+// peek returns collected changes without resetting the tracker.
+//	Returns: collected changes and true when all items shall be saved instead.
+func (c *changeTracker[T]) peek() (ChangeSet[T], bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.collect()
+}
+
+// collect converts pending changes into a change set. Must be called under lock.
+func (c *changeTracker[T]) collect() (ChangeSet[T], bool) {
+	full := c.full
+	changes := ChangeSet[T]{}
+	for _, key := range c.keys {
+		change := c.changes[key]
+		switch change.state {
+		case changeInserted:
+			changes.Inserted = append(changes.Inserted, change.item)
+		case changeUpdated:
+			changes.Updated = append(changes.Updated, change.item)
+		case changeDeleted:
+			changes.Deleted = append(changes.Deleted, change.id)
+		}
+	}
+	return changes, full
+}
+
+// restore returns changes that failed to save back to the tracker.
+// They are placed before changes collected since they were taken.
+func (c *changeTracker[T]) restore(changes ChangeSet[T], full bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	keys := c.keys
+	current := c.changes
+	c.keys = nil
+	c.changes = make(map[string]*pendingChange[T])
+	c.full = c.full || full
+
+	for _, item := range changes.Inserted {
+		c.recordSet(c.idOf(item), item, changeInserted)
+	}
+	for _, item := range changes.Updated {
+		c.recordSet(c.idOf(item), item, changeUpdated)
+	}
+	for _, id := range changes.Deleted {
+		c.recordDelete(id)
+	}
+
+	for _, key := range keys {
+		change := current[key]
+		if change.state == changeDeleted {
+			c.recordDelete(change.id)
+		} else {
+			c.recordSet(change.id, change.item, change.state)
+		}
+	}
+}
+
+func (c *changeTracker[T]) reset() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.resetUnsafe()
+}
+
+func (c *changeTracker[T]) resetUnsafe() {
+	c.keys = nil
+	c.changes = make(map[string]*pendingChange[T])
+	c.full = false
+}
+
+// idKey converts id into a map key.
+// Ids read from files and from items may have different types, so they are compared as strings.
+func idKey(id any) string {
+	return fmt.Sprint(id)
+}
@@ -0,0 +1,158 @@
+package persistence
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+)
+
+const (
+	// ConfigParamChecksum enables SHA-256 checksums of data files kept in "<path>.sha256"
+	ConfigParamChecksum = "options.checksum"
+	// ConfigParamOnCorruption defines what to do when data file doesn't match its checksum: "fail" or "recover"
+	ConfigParamOnCorruption = "options.on_corruption"
+)
+
+const (
+	// CorruptionFail fails loading of a corrupted data file
+	CorruptionFail = "fail"
+	// CorruptionRecover loads the newest valid backup instead of a corrupted data file and logs a warning
+	CorruptionRecover = "recover"
+)
+
+// checksumExtension is added to the path of data file to get the path of its checksum
+const checksumExtension = ".sha256"
+
+func checksumPath(path string) string {
+	return path + checksumExtension
+}
+
+// checksumOf calculates hex encoded SHA-256 checksum of the content
+func checksumOf(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// matchesChecksum checks if the content matches any of checksums separated by new lines
+func matchesChecksum(checksum string, data []byte) bool {
+	sum := checksumOf(data)
+	for _, value := range strings.Fields(checksum) {
+		if value == sum {
+			return true
+		}
+	}
+	return false
+}
+
+// readChecksum reads the checksum of a file with a given path.
+// During replacement of the file it contains the new and previous checksums on separate lines.
+//	Returns: the checksum and true or false when there is no checksum file.
+func readChecksum(path string) (string, bool, error) {
+	data, err := ioutil.ReadFile(checksumPath(path))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(string(data)), true, nil
+}
+
+// writeChecksum atomically replaces the checksum of a file with a given path
+func writeChecksum(path string, checksum string, mode os.FileMode) error {
+	sumPath := checksumPath(path)
+	tmpPath := sumPath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, []byte(checksum+"\n"), mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, sumPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// verify checks raw content of data file against its checksum.
+// Files without checksum, for instance written before checksums were enabled, are trusted.
+//	Returns: FileError when the content is corrupted or the checksum can't be read.
+func (c *dataFile) verify(correlationId string, path string, data []byte) error {
+	checksum, ok, err := readChecksum(path)
+	if err != nil {
+		return errors.NewFileError(
+			correlationId,
+			"READ_FAILED",
+			"Failed to read checksum of data file: "+path).
+			WithCause(err)
+	}
+	if ok && !matchesChecksum(checksum, data) {
+		return errors.NewFileError(
+			correlationId,
+			"CHECKSUM_MISMATCH",
+			"Data file doesn't match its checksum: "+path).
+			WithDetails("path", path)
+	}
+	return nil
+}
+
+// recover handles corrupted data file. In recover mode it returns raw content
+// of the newest backup with a valid checksum and logs a warning,
+// otherwise the error about corruption with the path of valid backup, if there is one.
+func (c *dataFile) recover(correlationId string, corruption error) ([]byte, error) {
+	var backup string
+	var data []byte
+	for i := 1; i <= c.backups; i++ {
+		path := c.backupPath(i)
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if checksum, ok, _ := readChecksum(path); ok && matchesChecksum(checksum, content) {
+			backup = path
+			data = content
+			break
+		}
+	}
+
+	if backup == "" {
+		return nil, corruption
+	}
+
+	if c.onCorruption != CorruptionRecover {
+		if appErr, ok := corruption.(*errors.ApplicationError); ok {
+			return nil, appErr.WithDetails("backup", backup)
+		}
+		return nil, corruption
+	}
+
+	c.logger.Warn(context.Background(), correlationId,
+		"Data file %s is corrupted, loaded backup %s instead", c.path, backup)
+	return data, nil
+}
+
+// writePendingChecksum writes the checksum of content that is about to replace the data file.
+// The previous checksum is kept as well, so a crash before or after the replacement
+// leaves the data file matching its checksum.
+func (c *dataFile) writePendingChecksum(checksum string) error {
+	previous, _, err := readChecksum(c.path)
+	if err != nil {
+		return err
+	}
+	if previous != "" && previous != checksum {
+		checksum += "\n" + previous
+	}
+	return writeChecksum(c.path, checksum, c.mode)
+}
+
+// completeChecksum drops the previous checksum after the data file was replaced.
+// The pending checksum already matches the new content, so a failure is only logged.
+func (c *dataFile) completeChecksum(correlationId string, checksum string) {
+	if err := writeChecksum(c.path, checksum, c.mode); err != nil {
+		c.logger.Warn(context.Background(), correlationId,
+			"Failed to update checksum of data file %s: %v", c.path, err)
+	}
+}
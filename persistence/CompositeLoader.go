@@ -0,0 +1,215 @@
+package persistence
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+)
+
+const (
+	// ConfigParamLoaderMode defines how CompositeLoader combines its loaders: "fallback" or "merge"
+	ConfigParamLoaderMode = "options.loader_mode"
+	// ConfigParamPrecedence defines which loader wins in merge mode of CompositeLoader: "first" or "last"
+	ConfigParamPrecedence = "options.precedence"
+)
+
+// CompositeLoaderMode defines how CompositeLoader combines results of its loaders
+type CompositeLoaderMode string
+
+const (
+	// CompositeLoaderFallback returns items of the first loader that has data.
+	CompositeLoaderFallback CompositeLoaderMode = "fallback"
+	// CompositeLoaderMerge combines items of all loaders by ids.
+	CompositeLoaderMerge CompositeLoaderMode = "merge"
+)
+
+// LoaderPrecedence defines which loader wins when items with the same id are merged
+type LoaderPrecedence string
+
+const (
+	// LoaderPrecedenceFirst keeps items of loaders that go first.
+	LoaderPrecedenceFirst LoaderPrecedence = "first"
+	// LoaderPrecedenceLast replaces items with items of loaders that go later.
+	LoaderPrecedenceLast LoaderPrecedence = "last"
+)
+
+// CompositeLoader is a loader that combines several loaders.
+//
+// In fallback mode the loaders are tried in order and the items of the first one
+// that has data are returned. A loader has no data when its data file doesn't exist,
+// for instance when a seed pattern of FsLoader matches no files, or it returns nil list
+// for an empty file. An empty list loaded from an existing data file is valid data,
+// so items deleted by the user don't come back from the seed. When no loader has data,
+// an empty list is returned.
+// In merge mode items of all loaders are combined by "Id" property, the items of
+// the first or the last loader win according to precedence. Items without ids are kept as is.
+// Other errors of the loaders are returned, so damaged data is never replaced silently.
+// Unknown loader mode or precedence fails the load with ConfigError.
+//
+// That allows to bootstrap a persistence from a seed dataset on the first run
+// and from its own data file afterwards, while the persister still saves all changes.
+//	Configuration parameters:
+//		- options:
+//			- loader_mode: "fallback" or "merge" (default: "fallback")
+//			- precedence: "first" or "last" loader wins in merge mode (default: "first")
+//	Typed params:
+//		- T any type
+//	Example:
+//		persister := NewJsonFilePersister[MyData]("./data/data.json")
+//		persistence := NewIdentifiableFilePersistence[MyData, string](persister)
+//		persistence.Loader = NewFallbackLoader[MyData](persister, NewFsLoader[MyData](seed, "seed/*.json"))
+//	Implements: ILoader, IConfigurable
+type CompositeLoader[T any] struct {
+	// Loaders are combined loaders in the order of their priority
+	Loaders []ILoader[T]
+	// Mode defines how results of the loaders are combined
+	Mode CompositeLoaderMode
+	// Precedence defines which loader wins in merge mode
+	Precedence LoaderPrecedence
+}
+
+// NewFallbackLoader creates a loader that returns items of the first loader that has data.
+//	Typed params:
+//		- T any type
+//	Parameters:
+//		- loaders ...ILoader[T] loaders to try in order
+//	Returns: *CompositeLoader[T] pointer on new CompositeLoader instance
+func NewFallbackLoader[T any](loaders ...ILoader[T]) *CompositeLoader[T] {
+	return &CompositeLoader[T]{
+		Loaders:    loaders,
+		Mode:       CompositeLoaderFallback,
+		Precedence: LoaderPrecedenceFirst,
+	}
+}
+
+// NewMergeLoader creates a loader that merges items of all loaders by ids.
+//	Typed params:
+//		- T any type
+//	Parameters:
+//		- precedence LoaderPrecedence which loader wins for items with the same id
+//		- loaders ...ILoader[T] loaders to merge
+//	Returns: *CompositeLoader[T] pointer on new CompositeLoader instance
+func NewMergeLoader[T any](precedence LoaderPrecedence, loaders ...ILoader[T]) *CompositeLoader[T] {
+	return &CompositeLoader[T]{
+		Loaders:    loaders,
+		Mode:       CompositeLoaderMerge,
+		Precedence: precedence,
+	}
+}
+
+// Configure component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config: ConfigParams configuration parameters to be set.
+func (c *CompositeLoader[T]) Configure(ctx context.Context, config *config.ConfigParams) {
+	if mode, ok := config.GetAsNullableString(ConfigParamLoaderMode); ok && mode != "" {
+		c.Mode = CompositeLoaderMode(strings.ToLower(strings.TrimSpace(mode)))
+	}
+	if precedence, ok := config.GetAsNullableString(ConfigParamPrecedence); ok && precedence != "" {
+		c.Precedence = LoaderPrecedence(strings.ToLower(strings.TrimSpace(precedence)))
+	}
+}
+
+// Path gets the path of data file of the first loader that reads a file,
+// so changes of that file can be watched by MemoryPersistence.
+//	Returns: the file path or empty string.
+func (c *CompositeLoader[T]) Path() string {
+	for _, loader := range c.Loaders {
+		if source, ok := loader.(pathSource); ok && source.Path() != "" {
+			return source.Path()
+		}
+	}
+	return ""
+}
+
+// Load data items from the combined loaders.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+// Returns: []T, error loaded items or error.
+func (c *CompositeLoader[T]) Load(ctx context.Context, correlationId string) ([]T, error) {
+	switch c.Mode {
+	case CompositeLoaderFallback, "":
+		return c.fallback(ctx, correlationId)
+	case CompositeLoaderMerge:
+		return c.merge(ctx, correlationId)
+	default:
+		return nil, errors.NewConfigError(
+			correlationId,
+			"INVALID_LOADER_MODE",
+			"Loader mode must be \"fallback\" or \"merge\" but was "+string(c.Mode)).
+			WithDetails("mode", string(c.Mode))
+	}
+}
+
+// fallback returns items of the first loader that has data
+func (c *CompositeLoader[T]) fallback(ctx context.Context, correlationId string) ([]T, error) {
+	for _, loader := range c.Loaders {
+		items, err := loader.Load(ctx, correlationId)
+		if isNoData(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if items != nil {
+			return items, nil
+		}
+	}
+	return []T{}, nil
+}
+
+// merge combines items of all loaders by ids
+func (c *CompositeLoader[T]) merge(ctx context.Context, correlationId string) ([]T, error) {
+	if c.Precedence != LoaderPrecedenceFirst && c.Precedence != LoaderPrecedenceLast && c.Precedence != "" {
+		return nil, errors.NewConfigError(
+			correlationId,
+			"INVALID_PRECEDENCE",
+			"Loader precedence must be \"first\" or \"last\" but was "+string(c.Precedence)).
+			WithDetails("precedence", string(c.Precedence))
+	}
+
+	result := make([]T, 0)
+	indexes := make(map[string]int)
+
+	for _, loader := range c.Loaders {
+		items, err := loader.Load(ctx, correlationId)
+		if isNoData(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			id := GetObjectId(item)
+			if id == nil {
+				result = append(result, item)
+				continue
+			}
+
+			key := idKey(id)
+			if index, ok := indexes[key]; ok {
+				if c.Precedence == LoaderPrecedenceLast {
+					result[index] = item
+				}
+				continue
+			}
+			indexes[key] = len(result)
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+// isNoData checks if a loader failed only because its data files don't exist
+func isNoData(err error) bool {
+	if os.IsNotExist(err) {
+		return true
+	}
+	appErr, ok := err.(*errors.ApplicationError)
+	return ok && appErr.Code == "FILE_NOT_FOUND"
+}
@@ -0,0 +1,485 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/convert"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+)
+
+const (
+	// ConfigParamDelimiter is a character that separates values in CSV file
+	ConfigParamDelimiter = "options.delimiter"
+	// ConfigParamHeader defines if the first row of CSV file contains column names
+	ConfigParamHeader = "options.header"
+	// ConfigParamColumns is a comma-separated list of columns in CSV file
+	ConfigParamColumns = "options.columns"
+	// ConfigParamMapping is a section that maps columns of CSV file to fields of data items
+	ConfigParamMapping = "mapping"
+)
+
+// CsvFilePersister is a persistence component that loads and saves data from/to flat CSV file.
+// It is used by FilePersistence and IdentifiableFilePersistence the same way as JsonFilePersister.
+//
+// Every row of the file is a data item and every column is one of its fields.
+// Columns are mapped to fields by "json" struct tags. Column names are taken
+// from the header row or from the columns option and can be mapped to different
+// field names in the mapping section. Values are converted into types of the fields
+// using commons convert package, nested structures are stored as JSON.
+// Invalid values are reported with FileError that contains the line of the file and the column.
+//	Important: this component is not thread save!
+//	Configuration parameters:
+//		- path to the file where data is stored
+//		- options:
+//			- delimiter: a character that separates values (default: ",")
+//			- header: true if the first row contains column names (default: true)
+//			- columns: comma-separated list of columns, required to load and save files without header.
+//				When saving it sets the order of columns (default: all fields of items)
+//			- file_mode: octal permissions of the data file (default: "0644")
+//			- backups: number of previous copies kept as "<path>.1", "<path>.2", ... (default: 0)
+//			- compress: true to compress the data file with gzip on save, compressed files are detected on load (default: false)
+//			- encryption_key: AES-256 key to encrypt the data file, see JsonFilePersister
+//			- checksum: true to keep SHA-256 checksum of the data file in "<path>.sha256" and verify it on load (default: false)
+//			- on_corruption: "fail" to return an error or "recover" to load the newest valid backup when the checksum doesn't match (default: "fail")
+//		- mapping:
+//			- <column>: a field name for the column (default: the column name)
+//	Typed params:
+//		- T any type
+//	Example:
+//		persister := NewCsvFilePersister[MyData]("./data/data.csv")
+//		persister.Configure(context.Background(), config.NewConfigParamsFromTuples(
+//			"options.delimiter", ";",
+//			"mapping.Customer Name", "name",
+//		))
+//		persistence := NewIdentifiableFilePersistence[MyData, string](persister)
+//	Implements: ILoader, ISaver, IConfigurable, IReferenceable
+type CsvFilePersister[T any] struct {
+	file      dataFile
+	delimiter rune
+	header    bool
+	columns   []string
+	mapping   map[string]string
+	convertor convert.IJSONEngine[T]
+}
+
+// NewCsvFilePersister creates a new instance of the persistence.
+//	Typed params:
+//		- T any type
+//	Parameters: path string (optional) a path to the file where data is stored.
+func NewCsvFilePersister[T any](path string) *CsvFilePersister[T] {
+	return &CsvFilePersister[T]{
+		file:      newDataFile(path),
+		delimiter: ',',
+		header:    true,
+		mapping:   make(map[string]string),
+		convertor: convert.NewDefaultCustomTypeJsonConvertor[T](),
+	}
+}
+
+// Path gets the file path where data is stored.
+//	Returns: the file path where data is stored.
+func (c *CsvFilePersister[T]) Path() string {
+	return c.file.path
+}
+
+// SetPath the file path where data is stored.
+//	Parameters:
+//		- value string the file path where data is stored.
+func (c *CsvFilePersister[T]) SetPath(value string) {
+	c.file.path = value
+}
+
+// Configure component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config: ConfigParams configuration parameters to be set.
+func (c *CsvFilePersister[T]) Configure(ctx context.Context, config *config.ConfigParams) {
+	c.file.configure(config)
+
+	if delimiter, ok := config.GetAsNullableString(ConfigParamDelimiter); ok && delimiter != "" {
+		if delimiter == "\\t" {
+			delimiter = "\t"
+		}
+		c.delimiter, _ = utf8.DecodeRuneInString(delimiter)
+	}
+	c.header = config.GetAsBooleanWithDefault(ConfigParamHeader, c.header)
+
+	if columns, ok := config.GetAsNullableString(ConfigParamColumns); ok && columns != "" {
+		c.columns = make([]string, 0)
+		for _, column := range strings.Split(columns, ",") {
+			c.columns = append(c.columns, strings.TrimSpace(column))
+		}
+	}
+
+	mapping := config.GetSection(ConfigParamMapping)
+	for _, column := range mapping.Keys() {
+		c.mapping[column] = mapping.GetAsString(column)
+	}
+}
+
+// SetReferences sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references refer.IReferences references to locate the component dependencies.
+func (c *CsvFilePersister[T]) SetReferences(ctx context.Context, references refer.IReferences) {
+	c.file.setReferences(ctx, references)
+}
+
+// Load data items from external CSV file.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+// Returns: []T, error loaded items or error.
+func (c *CsvFilePersister[T]) Load(ctx context.Context, correlationId string) ([]T, error) {
+	data, err := c.file.read(correlationId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = c.delimiter
+	reader.FieldsPerRecord = -1
+
+	columns := c.columns
+	if c.header {
+		header, err := reader.Read()
+		if err != nil {
+			return nil, c.readError(correlationId, err)
+		}
+		columns = make([]string, len(header))
+		for i, column := range header {
+			columns[i] = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		}
+	}
+	if len(columns) == 0 {
+		return nil, c.noColumnsError(correlationId)
+	}
+
+	fields := csvFieldTypes(reflect.TypeOf((*T)(nil)).Elem())
+	items := make([]T, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, c.readError(correlationId, err)
+		}
+		// Quoted values may span several lines, so errors refer to lines of the file
+		line, _ := reader.FieldPos(0)
+
+		if len(record) > len(columns) {
+			line, _ = reader.FieldPos(len(columns))
+			return nil, c.valueError(correlationId, line, len(columns)+1, "",
+				"Too many values in line "+strconv.Itoa(line)+" of data file: "+c.file.path)
+		}
+
+		values := make(map[string]any, len(record))
+		for i, cell := range record {
+			if cell == "" {
+				continue
+			}
+			field := c.fieldName(columns[i])
+			value, ok := convertCsvValue(cell, fields[field])
+			if !ok {
+				line, _ = reader.FieldPos(i)
+				return nil, c.valueError(correlationId, line, i+1, columns[i],
+					"Invalid value '"+cell+"' in line "+strconv.Itoa(line)+
+						", column '"+columns[i]+"' of data file: "+c.file.path)
+			}
+			values[field] = value
+		}
+
+		jsonData, err := json.Marshal(values)
+		if err != nil {
+			return nil, c.valueError(correlationId, line, 0, "",
+				"Failed to convert record in line "+strconv.Itoa(line)+" of data file: "+c.file.path).
+				WithCause(err)
+		}
+		item, err := c.convertor.FromJson(string(jsonData))
+		if err != nil {
+			return nil, c.valueError(correlationId, line, 0, "",
+				"Failed to convert record in line "+strconv.Itoa(line)+" of data file: "+c.file.path).
+				WithCause(err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Save given data items to external CSV file.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+//		- items []T list of data items to save
+//  Returns: error or nil for success.
+func (c *CsvFilePersister[T]) Save(ctx context.Context, correlationId string, items []T) error {
+	rows := make([]map[string]any, 0, len(items))
+	fieldOrder := make([]string, 0)
+	for _, item := range items {
+		jsonStr, err := c.convertor.ToJson(item)
+		if err != nil {
+			return errors.NewInternalError(correlationId, "CAN'T_CONVERT", "Failed convert to JSON").
+				WithCause(err)
+		}
+		values, keys, err := decodeCsvObject([]byte(jsonStr))
+		if err != nil {
+			return errors.NewInternalError(correlationId, "CAN'T_CONVERT", "Failed convert to CSV").
+				WithCause(err)
+		}
+		rows = append(rows, values)
+		fieldOrder = appendNewKeys(fieldOrder, keys)
+	}
+
+	columns := c.columns
+	if len(columns) == 0 {
+		if !c.header {
+			// The file couldn't be loaded without column names
+			return c.noColumnsError(correlationId)
+		}
+		columns = c.columnNames(fieldOrder)
+	}
+
+	return c.file.writeWith(correlationId, func(w io.Writer) error {
+		writer := csv.NewWriter(w)
+		writer.Comma = c.delimiter
+
+		if c.header {
+			if err := writer.Write(columns); err != nil {
+				return err
+			}
+		}
+		record := make([]string, len(columns))
+		for _, values := range rows {
+			for i, column := range columns {
+				value, err := formatCsvValue(values[c.fieldName(column)])
+				if err != nil {
+					return errors.NewInternalError(correlationId, "CAN'T_CONVERT", "Failed convert to CSV").
+						WithCause(err)
+				}
+				record[i] = value
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+}
+
+// fieldName gets a field of data item for a column
+func (c *CsvFilePersister[T]) fieldName(column string) string {
+	if field, ok := c.mapping[column]; ok && field != "" {
+		return field
+	}
+	return column
+}
+
+// columnNames gets columns for fields of data items using reverse mapping
+func (c *CsvFilePersister[T]) columnNames(fields []string) []string {
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		columns[i] = field
+		for column, mapped := range c.mapping {
+			if mapped == field {
+				columns[i] = column
+				break
+			}
+		}
+	}
+	return columns
+}
+
+func (c *CsvFilePersister[T]) readError(correlationId string, err error) error {
+	result := errors.NewFileError(
+		correlationId,
+		"READ_FAILED",
+		"Failed to parse data file: "+c.file.path).
+		WithCause(err)
+
+	if parseErr, ok := err.(*csv.ParseError); ok {
+		result.WithDetails("line", parseErr.Line).
+			WithDetails("column", parseErr.Column)
+	}
+	return result
+}
+
+func (c *CsvFilePersister[T]) noColumnsError(correlationId string) error {
+	return errors.NewConfigError(
+		correlationId,
+		"NO_COLUMNS",
+		"Columns of CSV file without header are not set: "+c.file.path).
+		WithDetails("path", c.file.path)
+}
+
+func (c *CsvFilePersister[T]) valueError(correlationId string, line int, index int,
+	column string, message string) *errors.ApplicationError {
+	result := errors.NewFileError(correlationId, "INVALID_VALUE", message).
+		WithDetails("path", c.file.path).
+		WithDetails("line", line)
+	if index > 0 {
+		result.WithDetails("column", index)
+	}
+	if column != "" {
+		result.WithDetails("column_name", column)
+	}
+	return result
+}
+
+// csvFieldTypes collects types of struct fields by their JSON names
+func csvFieldTypes(typ reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return fields
+	}
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName := strings.Split(tag, ",")[0]
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		if field.Anonymous && name == field.Name {
+			for key, value := range csvFieldTypes(field.Type) {
+				fields[key] = value
+			}
+			continue
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// convertCsvValue converts cell value into a value of the field type
+func convertCsvValue(cell string, typ reflect.Type) (any, bool) {
+	if typ == nil {
+		return cell, true
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == timeType {
+		value, ok := convert.DateTimeConverter.ToNullableDateTime(cell)
+		return value, ok
+	}
+
+	switch typ.Kind() {
+	case reflect.String:
+		return cell, true
+	case reflect.Bool:
+		return convert.BooleanConverter.ToNullableBoolean(cell)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if typ.PkgPath() == "time" && typ.Name() == "Duration" {
+			value, ok := convert.DurationConverter.ToNullableDuration(cell)
+			return int64(value), ok
+		}
+		return convert.LongConverter.ToNullableLong(cell)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return convert.LongConverter.ToNullableULong(cell)
+	case reflect.Float32, reflect.Float64:
+		return convert.DoubleConverter.ToNullableDouble(cell)
+	case reflect.Interface:
+		return cell, true
+	default:
+		// Nested structures are stored as JSON
+		var value any
+		if err := json.Unmarshal([]byte(cell), &value); err != nil {
+			return nil, false
+		}
+		return value, true
+	}
+}
+
+// formatCsvValue converts field value into cell value
+func formatCsvValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		data, err := json.Marshal(v)
+		return string(data), err
+	}
+}
+
+// decodeCsvObject parses JSON object keeping the order of its properties
+func decodeCsvObject(data []byte) (map[string]any, []string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	values := make(map[string]any)
+	keys := make([]string, 0)
+
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if token != json.Delim('{') {
+		return nil, nil, errors.NewBadRequestError("", "NOT_OBJECT", "Data item is not an object")
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key := token.(string)
+		var value any
+		if err := decoder.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		values[key] = value
+		keys = append(keys, key)
+	}
+	return values, keys, nil
+}
+
+func appendNewKeys(keys []string, newKeys []string) []string {
+	for _, key := range newKeys {
+		found := false
+		for _, k := range keys {
+			if k == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,579 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	"github.com/pip-services3-gox/pip-services3-components-gox/log"
+)
+
+const (
+	// ConfigParamFileMode is octal permissions of data files, for instance "0644"
+	ConfigParamFileMode = "options.file_mode"
+	// ConfigParamBackups is number of previous copies of data file to keep
+	ConfigParamBackups = "options.backups"
+	// ConfigParamCompress enables gzip compression of data files on save
+	ConfigParamCompress = "options.compress"
+)
+
+// DefaultFileMode is permissions of data files created by file persisters
+const DefaultFileMode os.FileMode = 0644
+
+// dataFile reads and writes data files for file persisters.
+// Writes are crash-safe: data is written into a temporary file in the same directory,
+// flushed to disk and atomically renamed over the data file, so readers
+// see either the previous or the new content, but never a truncated file.
+// Optionally it keeps a number of previous copies as "<path>.1", "<path>.2", ...
+// where "<path>.1" is the most recent one. Backups are rotated only after
+// the new content replaced the data file, so a failed write changes nothing.
+// Gzip-compressed files are detected by magic bytes and decompressed on read,
+// the compression on write is enabled by compress option.
+// When encryption key is set, files are encrypted after compression
+// and unencrypted files fail to read unless plaintext files are allowed.
+// When checksums are enabled, SHA-256 of the written content is kept in "<path>.sha256"
+// and backups have own checksums. The checksum is written before the content is replaced
+// together with the previous one, that is dropped after replacement. A file that doesn't match its checksum
+// fails to read or, in recover mode, is replaced by the newest valid backup.
+type dataFile struct {
+	path       string
+	mode       os.FileMode
+	backups    int
+	compress   bool
+	encryption fileEncryption
+	// stale is true when the last read file shall be re-encrypted with the current key
+	stale bool
+
+	checksum     bool
+	onCorruption string
+	logger       *log.CompositeLogger
+}
+
+// gzipMagic starts every gzip-compressed file
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func isGzip(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+func newDataFile(path string) dataFile {
+	return dataFile{
+		path:         path,
+		mode:         DefaultFileMode,
+		onCorruption: CorruptionFail,
+		logger:       log.NewCompositeLogger(),
+	}
+}
+
+// setReferences sets references to loggers and credential stores
+func (c *dataFile) setReferences(ctx context.Context, references refer.IReferences) {
+	c.logger.SetReferences(ctx, references)
+	c.encryption.setReferences(ctx, references)
+}
+
+// configure reads path, file mode and number of backups from configuration parameters
+func (c *dataFile) configure(config *config.ConfigParams) {
+	c.path = config.GetAsStringWithDefault(ConfigParamPath, c.path)
+
+	if mode, ok := config.GetAsNullableString(ConfigParamFileMode); ok && mode != "" {
+		if value, err := strconv.ParseUint(mode, 8, 32); err == nil {
+			c.mode = os.FileMode(value)
+		}
+	}
+
+	c.backups = config.GetAsIntegerWithDefault(ConfigParamBackups, c.backups)
+	c.compress = config.GetAsBooleanWithDefault(ConfigParamCompress, c.compress)
+	c.encryption.configure(config)
+	c.checksum = config.GetAsBooleanWithDefault(ConfigParamChecksum, c.checksum)
+	if mode, ok := config.GetAsNullableString(ConfigParamOnCorruption); ok && mode != "" {
+		c.onCorruption = strings.ToLower(strings.TrimSpace(mode))
+	}
+}
+
+// checkPath returns ConfigError when path is not set
+func (c *dataFile) checkPath(correlationId string) error {
+	if c.path == "" {
+		return errors.NewConfigError(correlationId, "NO_PATH", "Data file path is not set")
+	}
+	return nil
+}
+
+// backupPath gets path of previous copy with a given number starting from 1
+func (c *dataFile) backupPath(index int) string {
+	return c.path + "." + strconv.Itoa(index)
+}
+
+// read the whole content of data file.
+//	Returns: content of the file, os error when the file doesn't exist or FileError when it can't be read.
+func (c *dataFile) read(correlationId string) ([]byte, error) {
+	if err := c.checkPath(correlationId); err != nil {
+		return nil, err
+	}
+	if err := c.encryption.check(correlationId); err != nil {
+		return nil, err
+	}
+
+	c.stale = false
+	if _, err := os.Stat(c.path); os.IsNotExist(err) {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return nil, errors.NewFileError(
+			correlationId,
+			"READ_FAILED",
+			"Failed to read data file: "+c.path).
+			WithCause(err)
+	}
+
+	if c.checksum {
+		if err := c.verify(correlationId, c.path, data); err != nil {
+			if data, err = c.recover(correlationId, err); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := c.encryption.checkPlaintext(correlationId, c.path, data); err != nil {
+		return nil, err
+	}
+	c.stale = c.encryption.enabled() && !c.encryption.isCurrent(data)
+	if isEncrypted(data) {
+		if data, err = c.encryption.decrypt(correlationId, c.path, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if isGzip(data) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err == nil {
+			data, err = ioutil.ReadAll(reader)
+		}
+		if err != nil {
+			return nil, c.decompressError(correlationId, err)
+		}
+	}
+	return data, nil
+}
+
+// open data file for streaming read.
+// Files are read in memory when encryption or checksums are enabled,
+// because authentication and verification require the whole content.
+//	Returns: reader of the file, os error when the file doesn't exist or FileError when it can't be opened.
+func (c *dataFile) open(correlationId string) (io.ReadCloser, error) {
+	if err := c.checkPath(correlationId); err != nil {
+		return nil, err
+	}
+	if err := c.encryption.check(correlationId); err != nil {
+		return nil, err
+	}
+
+	if c.checksum || c.encryption.enabled() {
+		data, err := c.read(correlationId)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	file, err := os.Open(c.path)
+	if os.IsNotExist(err) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.NewFileError(
+			correlationId,
+			"READ_FAILED",
+			"Failed to read data file: "+c.path).
+			WithCause(err)
+	}
+
+	reader := bufio.NewReader(file)
+	if magic, _ := reader.Peek(len(encryptionMagic)); isEncrypted(magic) {
+		_ = file.Close()
+		data, err := c.read(correlationId)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	if magic, _ := reader.Peek(len(gzipMagic)); isGzip(magic) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			_ = file.Close()
+			return nil, c.decompressError(correlationId, err)
+		}
+		return &fileReader{Reader: gzipReader, file: file}, nil
+	}
+	return &fileReader{Reader: reader, file: file}, nil
+}
+
+// append adds content to the end of data file without rewriting it.
+// Content is compressed as a separate gzip member when the file is compressed,
+// otherwise it starts from a new line, so it isn't merged with a torn last line.
+// Encrypted files can't be appended, so they are rewritten with the new content.
+// When checksums are enabled, the whole file is read to verify it and to calculate
+// the new checksum, so appending costs as much as reading the file.
+//	Returns: FileError when the file can't be written or nil for success.
+func (c *dataFile) append(correlationId string, data []byte) (err error) {
+	if err := c.checkPath(correlationId); err != nil {
+		return err
+	}
+	if err := c.encryption.check(correlationId); err != nil {
+		return err
+	}
+
+	if c.encryption.enabled() {
+		content, err := c.read(correlationId)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			content = append(content, '\n')
+		}
+		return c.write(correlationId, append(content, data...))
+	}
+
+	var content []byte
+	if c.checksum {
+		content, err = ioutil.ReadFile(c.path)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.NewFileError(
+				correlationId,
+				"READ_FAILED",
+				"Failed to read data file: "+c.path).
+				WithCause(err)
+		}
+		if err == nil {
+			if err := c.verify(correlationId, c.path, content); err != nil {
+				return err
+			}
+		}
+	}
+
+	file, err := os.OpenFile(c.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, c.mode)
+	if err != nil {
+		return c.writeError(correlationId, err)
+	}
+
+	var size int64
+	if info, statErr := file.Stat(); statErr == nil {
+		size = info.Size()
+	}
+
+	var payload bytes.Buffer
+	compressed := c.compress
+	if size > 0 {
+		magic := make([]byte, len(encryptionMagic))
+		n, _ := file.ReadAt(magic, 0)
+		if isEncrypted(magic[:n]) {
+			_ = file.Close()
+			return errors.NewConfigError(
+				correlationId,
+				"NO_ENCRYPTION_KEY",
+				"Data file is encrypted, but encryption key is not set: "+c.path)
+		}
+		compressed = isGzip(magic[:n])
+
+		last := make([]byte, 1)
+		if _, readErr := file.ReadAt(last, size-1); readErr == nil && !compressed && last[0] != '\n' {
+			payload.WriteByte('\n')
+		}
+	}
+
+	if compressed {
+		writer := gzip.NewWriter(&payload)
+		if _, err = writer.Write(data); err == nil {
+			err = writer.Close()
+		}
+	} else {
+		payload.Write(data)
+	}
+
+	var checksum string
+	if err == nil && c.checksum {
+		hash := sha256.New()
+		hash.Write(content)
+		hash.Write(payload.Bytes())
+		checksum = hex.EncodeToString(hash.Sum(nil))
+		err = c.writePendingChecksum(checksum)
+	}
+	if err == nil {
+		_, err = file.Write(payload.Bytes())
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return c.writeError(correlationId, err)
+	}
+
+	if c.checksum {
+		c.completeChecksum(correlationId, checksum)
+	}
+	return nil
+}
+
+// write the whole content of data file.
+//	Returns: FileError when the file can't be written or nil for success.
+func (c *dataFile) write(correlationId string, data []byte) error {
+	return c.writeWith(correlationId, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeWith replaces data file with the content produced by writer function.
+//	Returns: FileError when the file can't be written or error returned by writer function.
+func (c *dataFile) writeWith(correlationId string, writer func(w io.Writer) error) (err error) {
+	if err := c.checkPath(correlationId); err != nil {
+		return err
+	}
+	if err := c.encryption.check(correlationId); err != nil {
+		return err
+	}
+
+	dir, name := filepath.Split(c.path)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+name+".tmp-")
+	if err != nil {
+		return c.writeError(correlationId, err)
+	}
+	tmpPath := tmp.Name()
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	hash := sha256.New()
+	buffer := bufio.NewWriter(io.MultiWriter(tmp, hash))
+	if err = c.encode(correlationId, buffer, writer); err != nil {
+		return err
+	}
+	if err = buffer.Flush(); err != nil {
+		return c.writeError(correlationId, err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return c.writeError(correlationId, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return c.writeError(correlationId, err)
+	}
+	if err = os.Chmod(tmpPath, c.mode); err != nil {
+		return c.writeError(correlationId, err)
+	}
+
+	backup, err := c.prepareBackup()
+	if err != nil {
+		return c.writeError(correlationId, err)
+	}
+
+	// The checksum is written before the data file is replaced,
+	// so the file matches it whether the rename happened or not
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if c.checksum {
+		if err = c.writePendingChecksum(checksum); err != nil {
+			backup.discard()
+			return c.writeError(correlationId, err)
+		}
+	}
+
+	if err = os.Rename(tmpPath, c.path); err != nil {
+		backup.discard()
+		return c.writeError(correlationId, err)
+	}
+
+	// The new content is already in place, so failed rotation doesn't fail the write
+	if rotateErr := c.rotateBackups(backup); rotateErr != nil {
+		c.logger.Warn(context.Background(), correlationId,
+			"Failed to rotate backups of data file %s: %v", c.path, rotateErr)
+	}
+
+	if c.checksum {
+		c.completeChecksum(correlationId, checksum)
+	}
+
+	syncDir(dir)
+	return nil
+}
+
+// encode writes content produced by writer function applying compression and encryption
+func (c *dataFile) encode(correlationId string, out io.Writer, writer func(w io.Writer) error) error {
+	target := out
+	var plain *bytes.Buffer
+	if c.encryption.enabled() {
+		plain = &bytes.Buffer{}
+		target = plain
+	}
+
+	if c.compress {
+		compressor := gzip.NewWriter(target)
+		if err := writer(compressor); err != nil {
+			return err
+		}
+		if err := compressor.Close(); err != nil {
+			return c.writeError(correlationId, err)
+		}
+	} else if err := writer(target); err != nil {
+		return err
+	}
+
+	if plain != nil {
+		data, err := c.encryption.encrypt(correlationId, plain.Bytes())
+		if err != nil {
+			return err
+		}
+		if _, err = out.Write(data); err != nil {
+			return c.writeError(correlationId, err)
+		}
+	}
+	return nil
+}
+
+// pendingBackup is a copy of the data file taken before the file is replaced
+type pendingBackup struct {
+	path     string
+	checksum string
+}
+
+// discard removes the copy when the data file wasn't replaced
+func (c *pendingBackup) discard() {
+	if c != nil {
+		_ = os.Remove(c.path)
+	}
+}
+
+// prepareBackup copies the current data file aside. The copy becomes "<path>.1"
+// only after the new content replaced the data file, so a failed write leaves
+// the data file and its backups untouched.
+//	Returns: the copy or nil when backups are disabled or there is no data file yet.
+func (c *dataFile) prepareBackup() (*pendingBackup, error) {
+	if c.backups <= 0 {
+		return nil, nil
+	}
+	if _, err := os.Stat(c.path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	// The data file stays in place until it is atomically replaced,
+	// so a hard link is used when possible instead of a rename
+	backup := &pendingBackup{path: c.backupPath(1) + ".tmp"}
+	_ = os.Remove(backup.path)
+	if err := os.Link(c.path, backup.path); err != nil {
+		if err := copyFile(c.path, backup.path, c.mode); err != nil {
+			_ = os.Remove(backup.path)
+			return nil, err
+		}
+	}
+	backup.checksum, _, _ = readChecksum(c.path)
+	return backup, nil
+}
+
+// rotateBackups shifts previous copies and turns the copy of replaced data file into "<path>.1"
+func (c *dataFile) rotateBackups(backup *pendingBackup) error {
+	if backup == nil {
+		return nil
+	}
+
+	for i := c.backups - 1; i > 0; i-- {
+		from := c.backupPath(i)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if err := os.Rename(from, c.backupPath(i+1)); err != nil {
+			return err
+		}
+		// Backups keep their checksums
+		_ = os.Remove(checksumPath(c.backupPath(i + 1)))
+		_ = os.Rename(checksumPath(from), checksumPath(c.backupPath(i+1)))
+	}
+
+	first := c.backupPath(1)
+	_ = os.Remove(checksumPath(first))
+	if err := os.Rename(backup.path, first); err != nil {
+		return err
+	}
+	if backup.checksum != "" {
+		return writeChecksum(first, backup.checksum, c.mode)
+	}
+	return nil
+}
+
+func (c *dataFile) writeError(correlationId string, err error) error {
+	return errors.NewFileError(
+		correlationId,
+		"WRITE_FAILED",
+		"Failed to write data file: "+c.path).
+		WithCause(err)
+}
+
+func (c *dataFile) decompressError(correlationId string, err error) error {
+	return errors.NewFileError(
+		correlationId,
+		"READ_FAILED",
+		"Failed to decompress data file: "+c.path).
+		WithCause(err)
+}
+
+// fileReader reads data file content and closes the file when reading is done
+type fileReader struct {
+	io.Reader
+	file *os.File
+}
+
+func (c *fileReader) Close() error {
+	return c.file.Close()
+}
+
+func copyFile(from string, to string, mode os.FileMode) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err = dst.Sync(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// syncDir flushes directory entries to make rename durable.
+// It is not supported on all platforms, so errors are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+}
@@ -0,0 +1,351 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/convert"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+)
+
+// ConfigParamExtension is an extension of item files in DirectoryPersister
+const ConfigParamExtension = "options.extension"
+
+// DirectoryPersister is a persistence component that stores every data item
+// in a separate JSON file "<dir>/<id>.json", that makes data files easy
+// to diff, merge and restore partially.
+//
+// On Load all item files in the directory are read. On Save only files of changed
+// items are written and files of deleted items are removed. As IChangeSaver
+// it receives changes from MemoryPersistence directly without comparing all items.
+// Every file is written atomically the same way as in JsonFilePersister.
+// Ids are escaped to be valid file names, so "a/b" is stored as "a%2Fb.json"
+// and ".cfg" as "%2Ecfg.json", because files starting with "." are skipped as temporary.
+// Items with empty ids are rejected.
+//
+// The data items must have "Id" property.
+//	Important: this component is thread save!
+//	Configuration parameters:
+//		- path to the directory where data is stored
+//		- options:
+//			- extension: extension of item files (default: ".json")
+//			- file_mode: octal permissions of item files (default: "0644")
+//			- compress: true to compress item files with gzip on save (default: false)
+//			- encryption_key: AES-256 key to encrypt item files, see JsonFilePersister
+//			- checksum: true to keep SHA-256 checksums of item files in "<file>.sha256" and verify them on load (default: false)
+//	Typed params:
+//		- T any type with "Id" property
+//	Example:
+//		persister := NewDirectoryPersister[MyData]("./data/items")
+//		persistence := NewIdentifiableFilePersistence[MyData, string](persister)
+//	Implements: ILoader, ISaver, IChangeSaver, IConfigurable, IReferenceable
+type DirectoryPersister[T any] struct {
+	dir       string
+	extension string
+	file      dataFile
+	convertor convert.IJSONEngine[T]
+
+	mtx     sync.Mutex
+	loaded  bool
+	entries map[string][]byte
+	// stale keeps keys of files that shall be re-encrypted with the current key
+	stale map[string]bool
+}
+
+// NewDirectoryPersister creates a new instance of the persister.
+//	Typed params:
+//		- T any type with "Id" property
+//	Parameters: path string (optional) a path to the directory where data is stored.
+func NewDirectoryPersister[T any](path string) *DirectoryPersister[T] {
+	return &DirectoryPersister[T]{
+		dir:       path,
+		extension: ".json",
+		file:      newDataFile(""),
+		convertor: convert.NewDefaultCustomTypeJsonConvertor[T](),
+		entries:   make(map[string][]byte),
+		stale:     make(map[string]bool),
+	}
+}
+
+// Path gets the path of the directory where data is stored.
+//	Returns: the directory path.
+func (c *DirectoryPersister[T]) Path() string {
+	return c.dir
+}
+
+// SetPath the path of the directory where data is stored.
+//	Parameters:
+//		- value string the directory path.
+func (c *DirectoryPersister[T]) SetPath(value string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.dir = value
+	c.loaded = false
+}
+
+// Configure component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config: ConfigParams configuration parameters to be set.
+func (c *DirectoryPersister[T]) Configure(ctx context.Context, config *config.ConfigParams) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.dir = config.GetAsStringWithDefault(ConfigParamPath, c.dir)
+	c.extension = config.GetAsStringWithDefault(ConfigParamExtension, c.extension)
+	if c.extension != "" && !strings.HasPrefix(c.extension, ".") {
+		c.extension = "." + c.extension
+	}
+	c.file.configure(config)
+	// Item files have own paths and don't keep backups
+	c.file.path = ""
+	c.file.backups = 0
+	c.loaded = false
+}
+
+// SetReferences sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references refer.IReferences references to locate the component dependencies.
+func (c *DirectoryPersister[T]) SetReferences(ctx context.Context, references refer.IReferences) {
+	c.file.setReferences(ctx, references)
+}
+
+// Load data items from item files in the directory.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+// Returns: []T, error loaded items or error.
+func (c *DirectoryPersister[T]) Load(ctx context.Context, correlationId string) ([]T, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err := c.loadState(correlationId); err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	items := make([]T, 0, len(keys))
+	for _, key := range keys {
+		item, err := c.convertor.FromJson(string(c.entries[key]))
+		if err != nil {
+			return nil, errors.NewFileError(
+				correlationId,
+				"READ_FAILED",
+				"Failed to parse data file: "+c.itemPath(key)).
+				WithCause(err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Save given data items. Only files of changed items are written
+// and files of items that are not in the list are removed.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+//		- items []T list of data items to save
+//  Returns: error or nil for success.
+func (c *DirectoryPersister[T]) Save(ctx context.Context, correlationId string, items []T) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.loaded {
+		if err := c.loadState(correlationId); err != nil {
+			return err
+		}
+	}
+
+	keys := make(map[string]bool, len(items))
+	for _, item := range items {
+		key, err := c.writeItem(correlationId, item)
+		if err != nil {
+			return err
+		}
+		keys[key] = true
+	}
+
+	for key := range c.entries {
+		if !keys[key] {
+			if err := c.removeItem(correlationId, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SaveChanges writes files of inserted and updated items and removes files of deleted items.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+//		- changes ChangeSet[T] inserted, updated and deleted items
+//  Returns: error or nil for success.
+func (c *DirectoryPersister[T]) SaveChanges(ctx context.Context, correlationId string, changes ChangeSet[T]) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.loaded {
+		if err := c.loadState(correlationId); err != nil {
+			return err
+		}
+	}
+
+	for _, list := range [][]T{changes.Inserted, changes.Updated} {
+		for _, item := range list {
+			if _, err := c.writeItem(correlationId, item); err != nil {
+				return err
+			}
+		}
+	}
+	for _, id := range changes.Deleted {
+		if err := c.removeItem(correlationId, idKey(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadState reads all item files in the directory
+func (c *DirectoryPersister[T]) loadState(correlationId string) error {
+	if c.dir == "" {
+		return errors.NewConfigError(correlationId, "NO_PATH", "Data directory path is not set")
+	}
+
+	if err := c.file.encryption.check(correlationId); err != nil {
+		return err
+	}
+
+	c.entries = make(map[string][]byte)
+	c.stale = make(map[string]bool)
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.NewFileError(
+			correlationId,
+			"READ_FAILED",
+			"Failed to read data directory: "+c.dir).
+			WithCause(err)
+	}
+
+	for _, info := range files {
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, c.extension) {
+			continue
+		}
+		key, err := url.PathUnescape(strings.TrimSuffix(name, c.extension))
+		if err != nil {
+			continue
+		}
+
+		file := c.itemFile(filepath.Join(c.dir, name))
+		data, err := file.read(correlationId)
+		if err != nil {
+			return err
+		}
+		c.entries[key] = data
+		if file.stale {
+			c.stale[key] = true
+		}
+	}
+
+	c.loaded = true
+	return nil
+}
+
+// writeItem writes item file when the item was changed
+//	Returns: key of the item or error.
+func (c *DirectoryPersister[T]) writeItem(correlationId string, item T) (string, error) {
+	id := GetObjectId(item)
+	if id == nil {
+		return "", errors.NewBadRequestError(
+			correlationId,
+			"NO_ID",
+			"Data item has no Id property")
+	}
+	key := idKey(id)
+	if key == "" {
+		return "", errors.NewBadRequestError(
+			correlationId,
+			"NO_ID",
+			"Data item has empty Id property")
+	}
+
+	json, err := c.convertor.ToJson(item)
+	if err != nil {
+		return "", errors.NewInternalError(correlationId, "CAN'T_CONVERT", "Failed convert to JSON").
+			WithCause(err)
+	}
+	data := []byte(json)
+
+	if old, ok := c.entries[key]; ok && bytes.Equal(old, data) && !c.stale[key] {
+		return key, nil
+	}
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return "", errors.NewFileError(
+			correlationId,
+			"WRITE_FAILED",
+			"Failed to create data directory: "+c.dir).
+			WithCause(err)
+	}
+
+	if err := c.file.encryption.check(correlationId); err != nil {
+		return "", err
+	}
+	file := c.itemFile(c.itemPath(key))
+	if err := file.write(correlationId, data); err != nil {
+		return "", err
+	}
+	c.entries[key] = data
+	delete(c.stale, key)
+	return key, nil
+}
+
+// removeItem removes item file
+func (c *DirectoryPersister[T]) removeItem(correlationId string, key string) error {
+	path := c.itemPath(key)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.NewFileError(
+			correlationId,
+			"WRITE_FAILED",
+			"Failed to remove data file: "+path).
+			WithCause(err)
+	}
+	_ = os.Remove(checksumPath(path))
+	delete(c.entries, key)
+	delete(c.stale, key)
+	return nil
+}
+
+// itemPath gets path of the file for item with a given key
+func (c *DirectoryPersister[T]) itemPath(key string) string {
+	name := url.PathEscape(key)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return filepath.Join(c.dir, name+c.extension)
+}
+
+// itemFile gets a data file with a given path that shares configuration of item files.
+// The encryption key shall be already resolved, so it isn't looked up for every file.
+func (c *DirectoryPersister[T]) itemFile(path string) dataFile {
+	file := c.file
+	file.path = path
+	return file
+}
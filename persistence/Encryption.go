@@ -0,0 +1,276 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"strings"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	"github.com/pip-services3-gox/pip-services3-components-gox/auth"
+)
+
+const (
+	// ConfigParamEncryptionKey is AES-256 key to encrypt data files, encoded as base64 or hex string
+	ConfigParamEncryptionKey = "options.encryption_key"
+	// ConfigParamOldEncryptionKeys is a comma-separated list of previous keys to decrypt data files after key rotation
+	ConfigParamOldEncryptionKeys = "options.old_encryption_keys"
+	// ConfigParamAllowPlaintext allows to load unencrypted data files when encryption key is set
+	ConfigParamAllowPlaintext = "options.allow_plaintext"
+)
+
+// encryptionMagic starts every data file encrypted by file persisters
+var encryptionMagic = []byte("PIPENC")
+
+const (
+	encryptionVersion   byte = 1
+	encryptionKeyIdSize      = 8
+)
+
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptionMagic)
+}
+
+// encryptionKey is AES-256-GCM cipher with identifier of its key
+type encryptionKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// fileEncryption encrypts data files with AES-256-GCM.
+// Encrypted file contains a header with version and identifier of the key,
+// a random nonce and the sealed content. The header is authenticated together
+// with the content, so any change of the file is detected on decryption.
+// Files are always encrypted with the current key, previous keys are used
+// only to decrypt files that were saved before key rotation.
+// The key is set in configuration or resolved from a credential on the first use.
+// Only credentials with explicit encryption_key or store_key are used, because
+// other credential parameters, like access_key, usually belong to other services.
+// Unencrypted files are rejected when the key is set,
+// unless plaintext files are allowed to migrate existing data.
+type fileEncryption struct {
+	keys           []encryptionKey
+	oldKeys        string
+	allowPlaintext bool
+	err            error
+
+	credentials []*auth.CredentialParams
+	references  refer.IReferences
+	resolved    bool
+}
+
+// configure reads current and previous keys from configuration parameters
+func (c *fileEncryption) configure(config *config.ConfigParams) {
+	c.allowPlaintext = config.GetAsBooleanWithDefault(ConfigParamAllowPlaintext, c.allowPlaintext)
+	c.oldKeys = config.GetAsStringWithDefault(ConfigParamOldEncryptionKeys, c.oldKeys)
+	credentials := make([]*auth.CredentialParams, 0)
+	for _, credential := range auth.NewManyCredentialParamsFromConfig(config) {
+		if credential.UseCredentialStore() || credential.GetAsString("encryption_key") != "" {
+			credentials = append(credentials, credential)
+		}
+	}
+	if len(credentials) > 0 {
+		c.credentials = credentials
+	}
+	c.resolved = false
+
+	if key, ok := config.GetAsNullableString(ConfigParamEncryptionKey); ok {
+		c.setKeys(key, c.oldKeys)
+	}
+}
+
+// setReferences sets references to credential stores to resolve the key
+func (c *fileEncryption) setReferences(ctx context.Context, references refer.IReferences) {
+	c.references = references
+	c.resolved = false
+}
+
+// setKeys parses the current key and a comma-separated list of previous keys
+func (c *fileEncryption) setKeys(key string, oldKeys string) {
+	c.keys = nil
+	c.err = nil
+	if key == "" {
+		return
+	}
+
+	values := []string{key}
+	if oldKeys != "" {
+		values = append(values, strings.Split(oldKeys, ",")...)
+	}
+
+	for _, value := range values {
+		encKey, err := newEncryptionKey(strings.TrimSpace(value))
+		if err != nil {
+			c.keys = nil
+			c.err = err
+			return
+		}
+		c.keys = append(c.keys, encKey)
+	}
+}
+
+// resolve looks up the key in the credential once
+func (c *fileEncryption) resolve(correlationId string) error {
+	if c.resolved {
+		return nil
+	}
+
+	resolver := auth.NewEmptyCredentialResolver()
+	for _, credential := range c.credentials {
+		resolver.Add(credential)
+	}
+	resolver.SetReferences(context.Background(), c.references)
+	credential, err := resolver.Lookup(context.Background(), correlationId)
+	if err != nil {
+		return err
+	}
+	if credential != nil {
+		if key := credential.GetAsString("encryption_key"); key != "" {
+			c.setKeys(key, credential.GetAsStringWithDefault("old_encryption_keys", c.oldKeys))
+		}
+	}
+
+	c.resolved = true
+	return nil
+}
+
+func (c *fileEncryption) enabled() bool {
+	return len(c.keys) > 0
+}
+
+// check resolves the key and returns ConfigError when configured keys are invalid
+func (c *fileEncryption) check(correlationId string) error {
+	if err := c.resolve(correlationId); err != nil {
+		return err
+	}
+	if c.err != nil {
+		return errors.NewConfigError(
+			correlationId,
+			"INVALID_ENCRYPTION_KEY",
+			"Encryption key must be 32 bytes encoded as base64 or hex string").
+			WithCause(c.err)
+	}
+	return nil
+}
+
+// checkPlaintext returns FileError when the key is set, but the content is not encrypted.
+// Unencrypted content is accepted only when plaintext files are allowed.
+func (c *fileEncryption) checkPlaintext(correlationId string, path string, data []byte) error {
+	if c.enabled() && !c.allowPlaintext && len(data) > 0 && !isEncrypted(data) {
+		return c.decryptError(correlationId, path, "Data file is not encrypted: ")
+	}
+	return nil
+}
+
+// encrypt content with the current key
+func (c *fileEncryption) encrypt(correlationId string, data []byte) ([]byte, error) {
+	if err := c.check(correlationId); err != nil {
+		return nil, err
+	}
+	key := c.keys[0]
+
+	header := make([]byte, 0, len(encryptionMagic)+1+encryptionKeyIdSize)
+	header = append(header, encryptionMagic...)
+	header = append(header, encryptionVersion)
+	header = append(header, key.id...)
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.NewInternalError(correlationId, "ENCRYPTION_FAILED", "Failed to generate nonce").
+			WithCause(err)
+	}
+
+	result := make([]byte, 0, len(header)+len(nonce)+len(data)+key.aead.Overhead())
+	result = append(result, header...)
+	result = append(result, nonce...)
+	return key.aead.Seal(result, nonce, data, header), nil
+}
+
+// isCurrent checks if content is encrypted with the current key.
+// Content that is not encrypted or encrypted with a previous key shall be re-encrypted.
+func (c *fileEncryption) isCurrent(data []byte) bool {
+	headerSize := len(encryptionMagic) + 1 + encryptionKeyIdSize
+	if !c.enabled() || !isEncrypted(data) || len(data) < headerSize {
+		return false
+	}
+	return bytes.Equal(data[len(encryptionMagic)+1:headerSize], c.keys[0].id)
+}
+
+// decrypt content of encrypted data file
+func (c *fileEncryption) decrypt(correlationId string, path string, data []byte) ([]byte, error) {
+	if err := c.check(correlationId); err != nil {
+		return nil, err
+	}
+	if !c.enabled() {
+		return nil, errors.NewConfigError(
+			correlationId,
+			"NO_ENCRYPTION_KEY",
+			"Data file is encrypted, but encryption key is not set: "+path)
+	}
+
+	headerSize := len(encryptionMagic) + 1 + encryptionKeyIdSize
+	if len(data) < headerSize || data[len(encryptionMagic)] != encryptionVersion {
+		return nil, c.decryptError(correlationId, path, "Unsupported format of encrypted data file: ")
+	}
+	header := data[:headerSize]
+	keyId := header[len(encryptionMagic)+1:]
+
+	for _, key := range c.keys {
+		if !bytes.Equal(key.id, keyId) {
+			continue
+		}
+		nonceSize := key.aead.NonceSize()
+		if len(data) < headerSize+nonceSize {
+			break
+		}
+		nonce := data[headerSize : headerSize+nonceSize]
+		result, err := key.aead.Open(nil, nonce, data[headerSize+nonceSize:], header)
+		if err != nil {
+			return nil, c.decryptError(correlationId, path, "Failed to decrypt data file, it was damaged or modified: ")
+		}
+		return result, nil
+	}
+
+	return nil, c.decryptError(correlationId, path, "Data file is encrypted with unknown key: ")
+}
+
+func (c *fileEncryption) decryptError(correlationId string, path string, message string) error {
+	return errors.NewFileError(correlationId, "DECRYPTION_FAILED", message+path).
+		WithDetails("path", path)
+}
+
+func newEncryptionKey(value string) (encryptionKey, error) {
+	var key []byte
+	var err error
+	if len(value) == 64 {
+		key, err = hex.DecodeString(value)
+	} else {
+		key, err = base64.StdEncoding.DecodeString(value)
+	}
+	if err != nil {
+		return encryptionKey{}, err
+	}
+	if len(key) != 32 {
+		return encryptionKey{}, aes.KeySizeError(len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return encryptionKey{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return encryptionKey{}, err
+	}
+
+	hash := sha256.Sum256(key)
+	return encryptionKey{id: hash[:encryptionKeyIdSize], aead: aead}, nil
+}
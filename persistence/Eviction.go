@@ -0,0 +1,199 @@
+package persistence
+
+import (
+	"strings"
+	"sync"
+)
+
+// EvictionPolicy defines the order in which items are removed
+// from a bounded MemoryPersistence when its capacity is exceeded.
+type EvictionPolicy string
+
+const (
+	// EvictionPolicyFifo removes the items that were inserted first.
+	EvictionPolicyFifo EvictionPolicy = "fifo"
+	// EvictionPolicyLru removes the items that were least recently used.
+	EvictionPolicyLru EvictionPolicy = "lru"
+	// EvictionPolicyLfu removes the items that were least frequently used.
+	// Items with equal usage are removed in least recently used order.
+	EvictionPolicyLfu EvictionPolicy = "lfu"
+)
+
+// ParseEvictionPolicy converts a string into EvictionPolicy.
+// Unknown values are converted into EvictionPolicyLru.
+//	Parameters:
+//		- value string a policy name: "fifo", "lru" or "lfu"
+//	Returns: EvictionPolicy parsed eviction policy
+func ParseEvictionPolicy(value string) EvictionPolicy {
+	switch EvictionPolicy(strings.ToLower(strings.TrimSpace(value))) {
+	case EvictionPolicyFifo:
+		return EvictionPolicyFifo
+	case EvictionPolicyLfu:
+		return EvictionPolicyLfu
+	default:
+		return EvictionPolicyLru
+	}
+}
+
+// itemStats keeps usage statistics of a single item
+type itemStats struct {
+	inserted uint64
+	accessed uint64
+	hits     uint64
+	// size is approximate size of item in bytes, negative when it is not calculated yet
+	size int64
+}
+
+// evictionTracker keeps usage statistics for items stored in MemoryPersistence.
+// Statistics are kept in the same order as items, so every change
+// of items slice must be reflected in the tracker.
+// The tracker has own lock, that allows to record reads under read lock of the persistence.
+type evictionTracker struct {
+	mtx   sync.Mutex
+	stats []itemStats
+	clock uint64
+}
+
+func (c *evictionTracker) tick() uint64 {
+	c.clock++
+	return c.clock
+}
+
+func (c *evictionTracker) newStats() itemStats {
+	now := c.tick()
+	return itemStats{inserted: now, accessed: now, hits: 1, size: -1}
+}
+
+// reset statistics for a given number of items
+func (c *evictionTracker) reset(count int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.stats = make([]itemStats, count)
+	for i := range c.stats {
+		c.stats[i] = c.newStats()
+	}
+}
+
+// sync adjusts the statistics to a given number of items.
+// It covers items that were added or removed by child structs directly.
+func (c *evictionTracker) sync(count int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if len(c.stats) > count {
+		c.stats = c.stats[:count]
+	}
+	for len(c.stats) < count {
+		c.stats = append(c.stats, c.newStats())
+	}
+}
+
+func (c *evictionTracker) insert() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.stats = append(c.stats, c.newStats())
+}
+
+func (c *evictionTracker) update(index int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if index >= 0 && index < len(c.stats) {
+		c.stats[index].accessed = c.tick()
+		c.stats[index].hits++
+		c.stats[index].size = -1
+	}
+}
+
+func (c *evictionTracker) touch(index int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if index >= 0 && index < len(c.stats) {
+		c.stats[index].accessed = c.tick()
+		c.stats[index].hits++
+	}
+}
+
+func (c *evictionTracker) remove(index int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if index >= 0 && index < len(c.stats) {
+		c.stats = append(c.stats[:index], c.stats[index+1:]...)
+	}
+}
+
+func (c *evictionTracker) snapshot() []itemStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	stats := make([]itemStats, len(c.stats))
+	copy(stats, c.stats)
+	return stats
+}
+
+func (c *evictionTracker) restore(stats []itemStats) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.stats = stats
+}
+
+// totalSize calculates approximate size of all items.
+// Sizes that are not known yet are calculated by sizeOf function.
+func (c *evictionTracker) totalSize(sizeOf func(index int) int64) int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	var total int64
+	for i := range c.stats {
+		if c.stats[i].size < 0 {
+			c.stats[i].size = sizeOf(i)
+		}
+		total += c.stats[i].size
+	}
+	return total
+}
+
+// victim finds index of the item that shall be evicted first according to the policy.
+// The item with keep index is never chosen, use -1 to consider all items.
+//	Returns: index of the item and its size or -1 when there is nothing to evict
+func (c *evictionTracker) victim(policy EvictionPolicy, keep int) (int, int64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	index := -1
+	for i, stats := range c.stats {
+		if i == keep {
+			continue
+		}
+		if index < 0 {
+			index = i
+			continue
+		}
+		victim := c.stats[index]
+		switch policy {
+		case EvictionPolicyFifo:
+			if stats.inserted < victim.inserted {
+				index = i
+			}
+		case EvictionPolicyLfu:
+			if stats.hits < victim.hits ||
+				stats.hits == victim.hits && stats.accessed < victim.accessed {
+				index = i
+			}
+		default:
+			if stats.accessed < victim.accessed {
+				index = i
+			}
+		}
+	}
+
+	if index < 0 {
+		return index, 0
+	}
+	return index, c.stats[index].size
+}
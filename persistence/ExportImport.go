@@ -0,0 +1,330 @@
+package persistence
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+)
+
+// DataFormat defines a format of data exported from and imported into MemoryPersistence
+type DataFormat string
+
+const (
+	// DataFormatJson is JSON array of items
+	DataFormatJson DataFormat = "json"
+	// DataFormatNdjson is JSON Lines with one item per line
+	DataFormatNdjson DataFormat = "ndjson"
+	// DataFormatCsv is CSV with a header row and one item per row
+	DataFormatCsv DataFormat = "csv"
+)
+
+// ImportMode defines how imported items are combined with stored items
+type ImportMode string
+
+const (
+	// ImportModeReplace removes all stored items and stores imported items instead.
+	ImportModeReplace ImportMode = "replace"
+	// ImportModeMerge updates stored items with the same ids and creates the others.
+	ImportModeMerge ImportMode = "merge"
+	// ImportModeInsertOnly creates items with new ids and skips items with existing ids.
+	ImportModeInsertOnly ImportMode = "insert_only"
+)
+
+// ImportReport describes the result of import into MemoryPersistence
+type ImportReport struct {
+	// Created is a number of created items
+	Created int
+	// Updated is a number of stored items replaced by imported items
+	Updated int
+	// Skipped is a number of imported items with existing ids in insert-only mode
+	Skipped int
+	// Failed is a number of records that can't be converted into items, are invalid or repeat ids
+	Failed int
+	// Errors describe failed records with their numbers starting from 1
+	Errors []error
+}
+
+// Export writes all stored items in a given format. It can be used to backup
+// the persistence regardless of the data source it uses.
+// CSV columns are mapped to fields by "json" struct tags and nested structures are stored as JSON.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string (optional) transaction id to trace execution through call chain.
+//		- writer io.Writer a destination of exported data
+//		- format DataFormat a format of exported data
+//	Returns: error or nil for success.
+func (c *MemoryPersistence[T]) Export(ctx context.Context, correlationId string,
+	writer io.Writer, format DataFormat) error {
+
+	c.Mtx.RLock()
+	defer c.Mtx.RUnlock()
+
+	var err error
+	switch format {
+	case DataFormatJson:
+		err = c.exportJson(correlationId, writer)
+	case DataFormatNdjson:
+		err = c.exportNdjson(correlationId, writer)
+	case DataFormatCsv:
+		err = c.exportCsv(correlationId, writer)
+	default:
+		return c.formatError(correlationId, format)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Logger.Trace(ctx, correlationId, "Exported %d items", len(c.Items))
+	return nil
+}
+
+// Import reads items in a given format and stores them according to import mode.
+// Items are matched with stored items by ids, items without ids are always created.
+// Records that can't be converted into items or repeat ids of previous records
+// are counted in the report as failed and don't stop the import.
+// Imported items are saved as a single change.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string (optional) transaction id to trace execution through call chain.
+//		- reader io.Reader a source of imported data
+//		- format DataFormat a format of imported data
+//		- mode ImportMode how imported items are combined with stored items
+//	Returns: ImportReport, error the import report or error.
+func (c *MemoryPersistence[T]) Import(ctx context.Context, correlationId string,
+	reader io.Reader, format DataFormat, mode ImportMode) (ImportReport, error) {
+
+	return c.importItems(ctx, correlationId, reader, format, mode, nil)
+}
+
+// importItems reads and stores imported items.
+// Every converted item is passed to prepare function (optional),
+// that can change it or return error to fail the record.
+func (c *MemoryPersistence[T]) importItems(ctx context.Context, correlationId string,
+	reader io.Reader, format DataFormat, mode ImportMode,
+	prepare func(item T) (T, error)) (ImportReport, error) {
+
+	var report ImportReport
+	switch mode {
+	case ImportModeReplace, ImportModeMerge, ImportModeInsertOnly:
+	default:
+		return report, errors.NewBadRequestError(
+			correlationId,
+			"UNSUPPORTED_IMPORT_MODE",
+			"Import mode "+string(mode)+" is not supported").
+			WithDetails("mode", mode)
+	}
+
+	if format != DataFormatJson && format != DataFormatNdjson && format != DataFormatCsv {
+		return report, c.formatError(correlationId, format)
+	}
+
+	// Every id is imported once, so each record is counted in the report only once
+	imported := make(map[string]bool)
+	decoder := itemDecoder[T]{
+		convertor: c.convertor,
+		prepare: func(item T) (T, error) {
+			var err error
+			if prepare != nil {
+				if item, err = prepare(item); err != nil {
+					return item, err
+				}
+			}
+			if key, ok := c.importKey(item); ok {
+				if imported[key] {
+					return item, errors.NewBadRequestError(
+						correlationId,
+						"DUPLICATE_ID",
+						"Item with id "+key+" was already imported").
+						WithDetails("id", key)
+				}
+				imported[key] = true
+			}
+			return item, nil
+		},
+		fail: func(record int, message string, cause error) error {
+			c.failImport(correlationId, record, &report, message, cause)
+			return nil
+		},
+		readError: func(err error) error {
+			return errors.NewBadRequestError(correlationId, "IMPORT_FAILED", "Failed to read imported data").
+				WithCause(err)
+		},
+	}
+	items, err := decoder.decode(format, reader)
+	if err != nil {
+		return report, err
+	}
+
+	c.Mtx.Lock()
+	snapshot := c.takeSnapshot()
+
+	indexes := make(map[string]int, len(c.Items))
+	existing := make(map[string]bool, len(c.Items))
+	for i, item := range c.Items {
+		if key, ok := c.importKey(item); ok {
+			indexes[key] = i
+			existing[key] = true
+		}
+	}
+
+	if mode == ImportModeReplace {
+		for _, item := range c.Items {
+			c.recordDelete(item)
+		}
+		c.Items = make([]T, 0, len(items))
+		c.tracker.reset(0)
+		indexes = make(map[string]int, len(items))
+	}
+
+	for _, item := range items {
+		key, ok := c.importKey(item)
+		index, found := indexes[key]
+		switch {
+		case ok && found && mode == ImportModeInsertOnly:
+			report.Skipped++
+		case ok && found:
+			c.Items[index] = c.cloneItem(item)
+			c.tracker.update(index)
+			c.recordUpdate(item)
+			report.Updated++
+		default:
+			c.Items = append(c.Items, c.cloneItem(item))
+			c.tracker.insert()
+			c.recordInsert(item)
+			if ok {
+				indexes[key] = len(c.Items) - 1
+			}
+			if ok && existing[key] {
+				// Replaced item with the same id
+				report.Updated++
+			} else {
+				report.Created++
+			}
+		}
+	}
+	evicted := c.evictItems(-1)
+
+	c.Logger.Trace(ctx, correlationId, "Imported items: %d created, %d updated, %d skipped, %d failed",
+		report.Created, report.Updated, report.Skipped, report.Failed)
+
+	err = c.completeWrite(ctx, correlationId, &c.Mtx, snapshot, evicted)
+	return report, err
+}
+
+// importKey gets a key of item id to match imported items with stored items
+func (c *MemoryPersistence[T]) importKey(item T) (string, bool) {
+	id := c.changes.idOf(item)
+	if id == nil {
+		return "", false
+	}
+	key := idKey(id)
+	return key, key != ""
+}
+
+func (c *MemoryPersistence[T]) exportJson(correlationId string, writer io.Writer) error {
+	w := bufio.NewWriter(writer)
+	_, _ = w.WriteString("[")
+	for i, item := range c.Items {
+		jsonStr, err := c.convertor.ToJson(item)
+		if err != nil {
+			return c.exportError(correlationId, err)
+		}
+		if i > 0 {
+			_, _ = w.WriteString(",")
+		}
+		_, _ = w.WriteString("\n" + jsonStr)
+	}
+	_, _ = w.WriteString("\n]\n")
+	return c.flushExport(correlationId, w)
+}
+
+func (c *MemoryPersistence[T]) exportNdjson(correlationId string, writer io.Writer) error {
+	w := bufio.NewWriter(writer)
+	for _, item := range c.Items {
+		jsonStr, err := c.convertor.ToJson(item)
+		if err != nil {
+			return c.exportError(correlationId, err)
+		}
+		_, _ = w.WriteString(jsonStr + "\n")
+	}
+	return c.flushExport(correlationId, w)
+}
+
+func (c *MemoryPersistence[T]) exportCsv(correlationId string, writer io.Writer) error {
+	rows := make([]map[string]any, 0, len(c.Items))
+	columns := make([]string, 0)
+	for _, item := range c.Items {
+		jsonStr, err := c.convertor.ToJson(item)
+		if err != nil {
+			return c.exportError(correlationId, err)
+		}
+		values, keys, err := decodeCsvObject([]byte(jsonStr))
+		if err != nil {
+			return c.exportError(correlationId, err)
+		}
+		rows = append(rows, values)
+		columns = appendNewKeys(columns, keys)
+	}
+
+	w := csv.NewWriter(writer)
+	if err := w.Write(columns); err != nil {
+		return c.exportError(correlationId, err)
+	}
+	record := make([]string, len(columns))
+	for _, values := range rows {
+		for i, column := range columns {
+			value, err := formatCsvValue(values[column])
+			if err != nil {
+				return c.exportError(correlationId, err)
+			}
+			record[i] = value
+		}
+		if err := w.Write(record); err != nil {
+			return c.exportError(correlationId, err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return c.exportError(correlationId, err)
+	}
+	return nil
+}
+
+func (c *MemoryPersistence[T]) flushExport(correlationId string, w *bufio.Writer) error {
+	if err := w.Flush(); err != nil {
+		return c.exportError(correlationId, err)
+	}
+	return nil
+}
+
+func (c *MemoryPersistence[T]) failImport(correlationId string, record int,
+	report *ImportReport, message string, cause error) {
+
+	err := errors.NewBadRequestError(
+		correlationId,
+		"INVALID_ITEM",
+		message+" in record "+strconv.Itoa(record)).
+		WithDetails("record", record)
+	if cause != nil {
+		err = err.WithCause(cause)
+	}
+	report.Failed++
+	report.Errors = append(report.Errors, err)
+}
+
+func (c *MemoryPersistence[T]) formatError(correlationId string, format DataFormat) error {
+	return errors.NewBadRequestError(
+		correlationId,
+		"UNSUPPORTED_FORMAT",
+		"Data format "+string(format)+" is not supported").
+		WithDetails("format", format)
+}
+
+func (c *MemoryPersistence[T]) exportError(correlationId string, err error) error {
+	return errors.NewInternalError(correlationId, "EXPORT_FAILED", "Failed to export items").
+		WithCause(err)
+}
@@ -0,0 +1,104 @@
+package persistence
+
+import (
+	"os"
+	"time"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+)
+
+const (
+	// ConfigParamLock enables advisory inter-process locking of data files
+	ConfigParamLock = "options.lock"
+	// ConfigParamLockTimeout is time in milliseconds to wait for a lock of data file
+	ConfigParamLockTimeout = "options.lock_timeout"
+)
+
+// DefaultLockTimeout is time to wait for a lock of data file
+const DefaultLockTimeout = 10 * time.Second
+
+// lockRetryInterval is a pause between attempts to acquire a lock
+const lockRetryInterval = 10 * time.Millisecond
+
+// fileLock is an advisory inter-process lock of a data file.
+// The data file is replaced by rename on every write, so the lock is held
+// on a separate "<path>.lock" file that is never removed.
+// Shared locks are taken by readers and exclusive locks by writers.
+// Every lock call opens own handle of the lock file and returns it to unlock,
+// so concurrent calls on the same persister never release each other's locks.
+// On platforms without file locking the lock does nothing.
+type fileLock struct {
+	enabled bool
+	timeout time.Duration
+}
+
+func newFileLock() fileLock {
+	return fileLock{timeout: DefaultLockTimeout}
+}
+
+// configure reads locking options from configuration parameters
+func (c *fileLock) configure(config *config.ConfigParams) {
+	c.enabled = config.GetAsBooleanWithDefault(ConfigParamLock, c.enabled)
+	c.timeout = time.Duration(config.GetAsLongWithDefault(ConfigParamLockTimeout,
+		c.timeout.Milliseconds())) * time.Millisecond
+}
+
+// lock acquires shared or exclusive lock of the data file with a given path.
+// The lock file is created with the same permissions as the data file.
+// It waits for the lock until timeout is reached.
+//	Returns: the handle of the acquired lock to pass to unlock, nil when locking is disabled, or error.
+func (c *fileLock) lock(correlationId string, path string, mode os.FileMode, exclusive bool) (*os.File, error) {
+	if !c.enabled || path == "" {
+		return nil, nil
+	}
+
+	lockPath := path + ".lock"
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, mode)
+	if os.IsNotExist(err) && !exclusive {
+		// The directory doesn't exist, so there is nothing to read
+		return nil, nil
+	}
+	if err != nil {
+		return nil, c.lockError(correlationId, lockPath, err)
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	for {
+		locked, err := tryLockFile(file, exclusive)
+		if err != nil {
+			_ = file.Close()
+			return nil, c.lockError(correlationId, lockPath, err)
+		}
+		if locked {
+			return file, nil
+		}
+		if !time.Now().Before(deadline) {
+			_ = file.Close()
+			return nil, errors.NewConflictError(
+				correlationId,
+				"FILE_LOCKED",
+				"Data file is locked by another process: "+path).
+				WithDetails("path", path).
+				WithDetails("timeout", c.timeout.Milliseconds())
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// unlock releases the lock with a handle returned by lock method
+func (c *fileLock) unlock(file *os.File) {
+	if file == nil {
+		return
+	}
+	_ = unlockFile(file)
+	_ = file.Close()
+}
+
+func (c *fileLock) lockError(correlationId string, path string, err error) error {
+	return errors.NewFileError(
+		correlationId,
+		"LOCK_FAILED",
+		"Failed to lock data file: "+path).
+		WithCause(err)
+}
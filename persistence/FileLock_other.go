@@ -0,0 +1,15 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+
+package persistence
+
+import "os"
+
+// tryLockFile does nothing on platforms without file locking
+func tryLockFile(file *os.File, exclusive bool) (bool, error) {
+	return true, nil
+}
+
+// unlockFile does nothing on platforms without file locking
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package persistence
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLockFile takes flock of the file without waiting.
+//	Returns: true when the lock is acquired or false when it is held by another process.
+func tryLockFile(file *os.File, exclusive bool) (bool, error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	if err == syscall.EINTR {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// unlockFile releases flock of the file
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
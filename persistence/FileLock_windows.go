@@ -0,0 +1,53 @@
+//go:build windows
+
+package persistence
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// tryLockFile takes LockFileEx lock of the first byte of the file without waiting.
+//	Returns: true when the lock is acquired or false when it is held by another process.
+func tryLockFile(file *os.File, exclusive bool) (bool, error) {
+	flags := uint32(lockfileFailImmediately)
+	if exclusive {
+		flags |= lockfileExclusiveLock
+	}
+
+	var overlapped syscall.Overlapped
+	result, _, err := procLockFileEx.Call(file.Fd(), uintptr(flags), 0, 1, 0,
+		uintptr(unsafe.Pointer(&overlapped)))
+	if result != 0 {
+		return true, nil
+	}
+	if err == errorLockViolation || err == syscall.ERROR_IO_PENDING {
+		return false, nil
+	}
+	return false, err
+}
+
+// unlockFile releases LockFileEx lock of the file
+func unlockFile(file *os.File) error {
+	var overlapped syscall.Overlapped
+	result, _, err := procUnlockFileEx.Call(file.Fd(), 0, 1, 0,
+		uintptr(unsafe.Pointer(&overlapped)))
+	if result == 0 {
+		return err
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
 	cdata "github.com/pip-services3-gox/pip-services3-commons-gox/data"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/refer"
 )
 
 // FilePersistence is an abstract persistence component that stores data in flat files
@@ -17,6 +18,7 @@ import (
 //
 //	see MemoryPersistence
 //	see JsonFilePersister
+//	see YamlFilePersister
 //
 //	Configuration parameters:
 //		- path to the file where data is stored
@@ -62,20 +64,22 @@ import (
 //	Implements: IConfigurable
 type FilePersistence[T cdata.ICloneable[T]] struct {
 	*MemoryPersistence[T]
-	Persister *JsonFilePersister[T]
+	Persister IFilePersister[T]
 }
 
 // NewFilePersistence creates a new instance of the persistence.
 //	Parameters:
-//		- persister (optional) a persister component that loads and saves data from/to flat file.
+//		- persister (optional) a persister component that loads and saves data from/to flat file,
+//			for instance JsonFilePersister or YamlFilePersister. JsonFilePersister is used
+//			when the persister is nil or a nil pointer.
 //	Typed params:
 //		- T cdata.ICloneable[T] any type that implemented
 //			ICloneable interface of getting element
 // Returns: *FilePersistence[T] pointer on new FilePersistence instance
-func NewFilePersistence[T cdata.ICloneable[T]](persister *JsonFilePersister[T]) *FilePersistence[T] {
+func NewFilePersistence[T cdata.ICloneable[T]](persister IFilePersister[T]) *FilePersistence[T] {
 	c := &FilePersistence[T]{}
 	c.MemoryPersistence = NewMemoryPersistence[T]()
-	if persister == nil {
+	if isNilPersister(persister) {
 		persister = NewJsonFilePersister[T]("")
 	}
 	c.Loader = persister
@@ -89,5 +93,18 @@ func NewFilePersistence[T cdata.ICloneable[T]](persister *JsonFilePersister[T])
 //		- ctx context.Context
 //		- config configuration parameters to be set.
 func (c *FilePersistence[T]) Configure(ctx context.Context, conf *config.ConfigParams) {
+	c.MemoryPersistence.Configure(ctx, conf)
 	c.Persister.Configure(ctx, conf)
 }
+
+// SetReferences sets references to dependent components.
+// The references are also passed to the persister when it needs them.
+//	Parameters:
+//		- ctx context.Context
+//		- references refer.IReferences references to locate the component dependencies.
+func (c *FilePersistence[T]) SetReferences(ctx context.Context, references refer.IReferences) {
+	c.MemoryPersistence.SetReferences(ctx, references)
+	if referenceable, ok := c.Persister.(refer.IReferenceable); ok {
+		referenceable.SetReferences(ctx, references)
+	}
+}
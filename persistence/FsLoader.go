@@ -0,0 +1,174 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/convert"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// FsLoader is a loader that reads read-only seed data from any fs.FS,
+// for instance from embed.FS compiled into the binary. It is used as Loader
+// of MemoryPersistence or IdentifiableMemoryPersistence without Saver,
+// so the seed data is loaded on Open and all changes stay in memory.
+//
+// Files are selected by glob patterns supported by fs.Glob and loaded
+// in the order of patterns and names. The format is chosen by file extension:
+// ".json" for JSON array of items, ".ndjson" or ".jsonl" for JSON Lines,
+// ".yaml" or ".yml" for YAML list of items and ".csv" for CSV with a header row.
+// A pattern that matches no files or a record that can't be converted into item
+// fails the load, so a broken seed dataset is noticed right away.
+// Missing files are reported with FILE_NOT_FOUND code, so CompositeLoader
+// in fallback mode moves on to the next loader.
+//	Typed params:
+//		- T any type
+//	Example:
+//		//go:embed seed/*.json
+//		var seed embed.FS
+//
+//		persistence := NewIdentifiableMemoryPersistence[MyData, string]()
+//		persistence.Loader = NewFsLoader[MyData](seed, "seed/*.json")
+//		err := persistence.Open(context.Background(), "123")
+//	Implements: ILoader
+type FsLoader[T any] struct {
+	fsys      fs.FS
+	patterns  []string
+	convertor convert.IJSONEngine[T]
+}
+
+// NewFsLoader creates a new instance of the loader.
+//	Typed params:
+//		- T any type
+//	Parameters:
+//		- fsys fs.FS a file system with data files
+//		- patterns ...string glob patterns of data files
+//	Returns: *FsLoader[T] pointer on new FsLoader instance
+func NewFsLoader[T any](fsys fs.FS, patterns ...string) *FsLoader[T] {
+	return &FsLoader[T]{
+		fsys:      fsys,
+		patterns:  patterns,
+		convertor: convert.NewDefaultCustomTypeJsonConvertor[T](),
+	}
+}
+
+// Load data items from all data files that match the patterns.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+// Returns: []T, error loaded items or error.
+func (c *FsLoader[T]) Load(ctx context.Context, correlationId string) ([]T, error) {
+	items := make([]T, 0)
+	loaded := make(map[string]bool)
+
+	for _, pattern := range c.patterns {
+		names, err := fs.Glob(c.fsys, pattern)
+		if err != nil {
+			return nil, errors.NewConfigError(
+				correlationId,
+				"INVALID_PATTERN",
+				"Invalid pattern of data files: "+pattern).
+				WithCause(err)
+		}
+		if len(names) == 0 {
+			return nil, errors.NewFileError(
+				correlationId,
+				"FILE_NOT_FOUND",
+				"No data files match pattern: "+pattern).
+				WithDetails("pattern", pattern)
+		}
+
+		for _, name := range names {
+			if loaded[name] {
+				continue
+			}
+			loaded[name] = true
+
+			fileItems, err := c.loadFile(correlationId, name)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, fileItems...)
+		}
+	}
+	return items, nil
+}
+
+// loadFile reads items from a data file in the format defined by its extension
+func (c *FsLoader[T]) loadFile(correlationId string, name string) ([]T, error) {
+	data, err := fs.ReadFile(c.fsys, name)
+	if err != nil {
+		return nil, errors.NewFileError(
+			correlationId,
+			"READ_FAILED",
+			"Failed to read data file: "+name).
+			WithCause(err)
+	}
+
+	var format DataFormat
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json":
+		format = DataFormatJson
+	case ".ndjson", ".jsonl":
+		format = DataFormatNdjson
+	case ".csv":
+		format = DataFormatCsv
+	case ".yaml", ".yml":
+		format = DataFormatJson
+		if data, err = yamlToJson(data); err != nil {
+			return nil, c.readError(correlationId, name, err)
+		}
+	default:
+		return nil, errors.NewConfigError(
+			correlationId,
+			"UNSUPPORTED_FORMAT",
+			"Format of data file is not supported: "+name).
+			WithDetails("path", name)
+	}
+
+	decoder := itemDecoder[T]{
+		convertor: c.convertor,
+		fail: func(record int, message string, cause error) error {
+			err := errors.NewFileError(
+				correlationId,
+				"INVALID_ITEM",
+				message+" in record "+strconv.Itoa(record)+" of data file: "+name).
+				WithDetails("record", record).
+				WithDetails("path", name)
+			if cause != nil {
+				err = err.WithCause(cause)
+			}
+			return err
+		},
+		readError: func(err error) error {
+			return c.readError(correlationId, name, err)
+		},
+	}
+	return decoder.decode(format, bytes.NewReader(data))
+}
+
+func (c *FsLoader[T]) readError(correlationId string, name string, err error) error {
+	return errors.NewFileError(
+		correlationId,
+		"READ_FAILED",
+		"Failed to parse data file: "+name).
+		WithCause(err)
+}
+
+// yamlToJson converts YAML document into JSON
+func yamlToJson(data []byte) ([]byte, error) {
+	var value any
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
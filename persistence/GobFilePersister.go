@@ -0,0 +1,184 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+)
+
+// GobFormatVersion is the current version of binary data files written by GobFilePersister
+const GobFormatVersion uint16 = 1
+
+// gobMagic starts every binary data file written by GobFilePersister
+var gobMagic = []byte("PIPGOB")
+
+// GobFilePersister is a persistence component that loads and saves data from/to
+// binary file encoded with encoding/gob. It is faster than JSON for large
+// datasets and is used by FilePersistence and IdentifiableFilePersistence
+// the same way as JsonFilePersister.
+//
+// The file starts with a header that contains "PIPGOB" signature, format version
+// and number of items, followed by gob-encoded items. Files with unknown signature
+// or newer version are rejected instead of being decoded into garbage.
+// Items are encoded with their exported fields, so types with interface fields
+// must be registered with gob.Register.
+//	Important: this component is not thread save!
+//	Configuration parameters:
+//		- path to the file where data is stored
+//		- options:
+//			- file_mode: octal permissions of the data file (default: "0644")
+//			- backups: number of previous copies kept as "<path>.1", "<path>.2", ... (default: 0)
+//			- compress: true to compress the data file with gzip on save, compressed files are detected on load (default: false)
+//			- encryption_key: AES-256 key to encrypt the data file, see JsonFilePersister
+//			- checksum: true to keep SHA-256 checksum of the data file in "<path>.sha256" and verify it on load (default: false)
+//			- on_corruption: "fail" to return an error or "recover" to load the newest valid backup when the checksum doesn't match (default: "fail")
+//	Typed params:
+//		- T any type supported by encoding/gob
+//	Example:
+//		persister := NewGobFilePersister[MyData]("./data/data.gob")
+//		persistence := NewIdentifiableFilePersistence[MyData, string](persister)
+//	Implements: ILoader, ISaver, IConfigurable, IReferenceable
+type GobFilePersister[T any] struct {
+	file dataFile
+}
+
+// NewGobFilePersister creates a new instance of the persistence.
+//	Typed params:
+//		- T any type supported by encoding/gob
+//	Parameters: path string (optional) a path to the file where data is stored.
+func NewGobFilePersister[T any](path string) *GobFilePersister[T] {
+	return &GobFilePersister[T]{
+		file: newDataFile(path),
+	}
+}
+
+// Path gets the file path where data is stored.
+//	Returns: the file path where data is stored.
+func (c *GobFilePersister[T]) Path() string {
+	return c.file.path
+}
+
+// SetPath the file path where data is stored.
+//	Parameters:
+//		- value string the file path where data is stored.
+func (c *GobFilePersister[T]) SetPath(value string) {
+	c.file.path = value
+}
+
+// Configure component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config: ConfigParams configuration parameters to be set.
+func (c *GobFilePersister[T]) Configure(ctx context.Context, config *config.ConfigParams) {
+	c.file.configure(config)
+}
+
+// SetReferences sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references refer.IReferences references to locate the component dependencies.
+func (c *GobFilePersister[T]) SetReferences(ctx context.Context, references refer.IReferences) {
+	c.file.setReferences(ctx, references)
+}
+
+// Load data items from external binary file.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+// Returns: []T, error loaded items or error.
+func (c *GobFilePersister[T]) Load(ctx context.Context, correlationId string) ([]T, error) {
+	file, err := c.file.open(correlationId)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	if _, err := reader.Peek(1); err == io.EOF {
+		return nil, nil
+	}
+
+	header := make([]byte, len(gobMagic)+2+8)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, c.readError(correlationId, err)
+	}
+	if !bytes.Equal(header[:len(gobMagic)], gobMagic) {
+		return nil, errors.NewFileError(
+			correlationId,
+			"INVALID_FORMAT",
+			"Data file is not a binary data file: "+c.file.path)
+	}
+	version := binary.BigEndian.Uint16(header[len(gobMagic):])
+	if version > GobFormatVersion {
+		return nil, errors.NewFileError(
+			correlationId,
+			"UNSUPPORTED_VERSION",
+			"Unsupported version "+strconv.Itoa(int(version))+" of data file: "+c.file.path).
+			WithDetails("version", version)
+	}
+	count := binary.BigEndian.Uint64(header[len(gobMagic)+2:])
+
+	// Damaged header shall not cause a huge allocation
+	capacity := count
+	if capacity > 1<<16 {
+		capacity = 1 << 16
+	}
+
+	decoder := gob.NewDecoder(reader)
+	items := make([]T, 0, capacity)
+	for i := uint64(0); i < count; i++ {
+		var item T
+		if err := decoder.Decode(&item); err != nil {
+			return nil, c.readError(correlationId, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Save given data items to external binary file.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+//		- items []T list of data items to save
+//  Returns: error or nil for success.
+func (c *GobFilePersister[T]) Save(ctx context.Context, correlationId string, items []T) error {
+	return c.file.writeWith(correlationId, func(w io.Writer) error {
+		header := make([]byte, len(gobMagic)+2+8)
+		copy(header, gobMagic)
+		binary.BigEndian.PutUint16(header[len(gobMagic):], GobFormatVersion)
+		binary.BigEndian.PutUint64(header[len(gobMagic)+2:], uint64(len(items)))
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+
+		encoder := gob.NewEncoder(w)
+		for i := range items {
+			if err := encoder.Encode(&items[i]); err != nil {
+				return errors.NewInternalError(correlationId, "CAN'T_CONVERT", "Failed convert to gob").
+					WithCause(err)
+			}
+		}
+		return nil
+	})
+}
+
+func (c *GobFilePersister[T]) readError(correlationId string, err error) error {
+	return errors.NewFileError(
+		correlationId,
+		"READ_FAILED",
+		"Failed to parse data file: "+c.file.path).
+		WithCause(err)
+}
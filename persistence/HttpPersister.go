@@ -0,0 +1,394 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/convert"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+)
+
+const (
+	// ConfigParamUrl is URL of remote JSON dataset
+	ConfigParamUrl = "url"
+	// ConfigParamTimeout is timeout of HTTP requests in milliseconds
+	ConfigParamTimeout = "options.timeout"
+	// ConfigParamRetries is number of retries of failed HTTP requests
+	ConfigParamRetries = "options.retries"
+	// ConfigParamRetryTimeout is initial pause between retries in milliseconds, doubled for every next retry
+	ConfigParamRetryTimeout = "options.retry_timeout"
+)
+
+// HttpPersister is a persistence component that loads and saves a JSON array of items
+// from/to a remote HTTP endpoint. It is used as Loader and Saver of MemoryPersistence
+// or IdentifiableMemoryPersistence to sync data with a central file service.
+//
+// Load sends GET request with ETag of the previous response in If-None-Match header,
+// so unchanged data is not transferred again. Save sends PUT request with the known ETag
+// in If-Match header, or If-None-Match: * when the data was never loaded,
+// and returns ConflictError "DATA_CHANGED" when the remote data was changed meanwhile.
+// Servers that don't return ETag can't detect concurrent changes: after the data was loaded
+// without ETag, Save sends PUT without precondition and the last writer wins.
+// Requests that fail because of network errors or 5xx and 429 responses are retried
+// with exponential backoff, or after the pause requested by Retry-After header.
+// A requested pause longer than the timeout is not waited for, the failed response
+// is returned as error right away, because the persistence is locked while it waits.
+// When a retried Save is rejected because an earlier attempt was already applied
+// and only its response was lost, the remote data is read back and the save
+// succeeds if it contains the saved items. Every attempt is limited by the timeout
+// with its own context, so Client is never changed and can be shared.
+// Missing dataset (404) is loaded as os not exist error, so MemoryPersistence opens empty.
+//	Important: this component is thread save!
+//	Configuration parameters:
+//		- url: URL of remote JSON dataset
+//		- options:
+//			- timeout: timeout of HTTP requests in milliseconds (default: 10000)
+//			- retries: number of retries of failed requests (default: 3)
+//			- retry_timeout: initial pause between retries in milliseconds (default: 100)
+//	Typed params:
+//		- T any type
+//	Example:
+//		persister := NewHttpPersister[MyData]("https://files.example.com/datasets/countries.json")
+//		persistence := NewIdentifiableMemoryPersistence[MyData, string]()
+//		persistence.Loader = persister
+//		persistence.Saver = persister
+//		err := persistence.Open(context.Background(), "123")
+//	Implements: ILoader, ISaver, IConfigurable
+type HttpPersister[T any] struct {
+	// Client sends HTTP requests, it can be replaced to customize transport.
+	// When it is nil http.DefaultClient is used.
+	Client *http.Client
+
+	mtx          sync.Mutex
+	url          string
+	timeout      time.Duration
+	retries      int
+	retryTimeout time.Duration
+	convertor    convert.IJSONEngine[[]T]
+
+	// etag and body of the last successful response
+	etag string
+	body []byte
+}
+
+// NewHttpPersister creates a new instance of the persister.
+//	Typed params:
+//		- T any type
+//	Parameters: url string (optional) URL of remote JSON dataset.
+//	Returns: *HttpPersister[T] pointer on new HttpPersister instance
+func NewHttpPersister[T any](url string) *HttpPersister[T] {
+	c := &HttpPersister[T]{
+		url:          url,
+		timeout:      10 * time.Second,
+		retries:      3,
+		retryTimeout: 100 * time.Millisecond,
+		convertor:    convert.NewDefaultCustomTypeJsonConvertor[[]T](),
+	}
+	c.Client = &http.Client{}
+	return c
+}
+
+// Url gets URL of remote JSON dataset.
+//	Returns: the dataset URL.
+func (c *HttpPersister[T]) Url() string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.url
+}
+
+// Configure component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config: ConfigParams configuration parameters to be set.
+func (c *HttpPersister[T]) Configure(ctx context.Context, config *config.ConfigParams) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	url := config.GetAsStringWithDefault(ConfigParamUrl, c.url)
+	if url != c.url {
+		c.url = url
+		c.etag = ""
+		c.body = nil
+	}
+	c.timeout = time.Duration(config.GetAsLongWithDefault(ConfigParamTimeout,
+		c.timeout.Milliseconds())) * time.Millisecond
+	c.retries = config.GetAsIntegerWithDefault(ConfigParamRetries, c.retries)
+	c.retryTimeout = time.Duration(config.GetAsLongWithDefault(ConfigParamRetryTimeout,
+		c.retryTimeout.Milliseconds())) * time.Millisecond
+}
+
+// Load data items from remote JSON dataset.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+// Returns: []T, error loaded items or error.
+func (c *HttpPersister[T]) Load(ctx context.Context, correlationId string) ([]T, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err := c.checkUrl(correlationId); err != nil {
+		return nil, err
+	}
+
+	response, body, _, err := c.send(ctx, correlationId, func(ctx context.Context) (*http.Request, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Accept", "application/json")
+		if c.etag != "" && c.body != nil {
+			request.Header.Set("If-None-Match", c.etag)
+		}
+		return request, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch response.StatusCode {
+	case http.StatusNotModified:
+		body = c.body
+	case http.StatusNotFound:
+		c.etag = ""
+		c.body = nil
+		return nil, &os.PathError{Op: "get", Path: c.url, Err: os.ErrNotExist}
+	case http.StatusOK:
+		c.etag = response.Header.Get("ETag")
+		c.body = body
+	default:
+		return nil, c.statusError(correlationId, response)
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+	items, err := c.convertor.FromJson(string(body))
+	if err != nil {
+		return nil, errors.NewInvocationError(
+			correlationId,
+			"READ_FAILED",
+			"Failed to parse data from "+c.url).
+			WithCause(err)
+	}
+	return items, nil
+}
+
+// Save given data items to remote JSON dataset.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+//		- items []T list of data items to save
+//  Returns: error or nil for success.
+func (c *HttpPersister[T]) Save(ctx context.Context, correlationId string, items []T) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err := c.checkUrl(correlationId); err != nil {
+		return err
+	}
+
+	if items == nil {
+		items = []T{}
+	}
+	json, err := c.convertor.ToJson(items)
+	if err != nil {
+		return errors.NewInternalError(correlationId, "CAN'T_CONVERT", "Failed convert to JSON").
+			WithCause(err)
+	}
+	body := []byte(json)
+
+	response, _, retried, err := c.send(ctx, correlationId, func(ctx context.Context) (*http.Request, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodPut, c.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		if c.etag != "" {
+			request.Header.Set("If-Match", c.etag)
+		} else if c.body == nil {
+			// The dataset is not known yet, so it shall not exist
+			request.Header.Set("If-None-Match", "*")
+		}
+		// The dataset was loaded without ETag, so the server can't check it and the last writer wins
+		return request, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		c.etag = response.Header.Get("ETag")
+		c.body = body
+		return nil
+	case http.StatusPreconditionFailed:
+		if retried && c.isSaved(ctx, correlationId, body) {
+			return nil
+		}
+		return errors.NewConflictError(
+			correlationId,
+			"DATA_CHANGED",
+			"Remote data was changed since it was loaded: "+c.url).
+			WithDetails("url", c.url)
+	default:
+		return c.statusError(correlationId, response)
+	}
+}
+
+// send sends a request created by a given function and retries it
+// after network errors and responses with 5xx and 429 statuses.
+// Every attempt gets its own context limited by the timeout.
+//	Returns: the last response with its body, flag that the request was sent
+//	more than once, or ConnectionError.
+func (c *HttpPersister[T]) send(ctx context.Context, correlationId string,
+	newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, []byte, bool, error) {
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	pause := c.retryTimeout
+	for attempt := 0; ; attempt++ {
+		requestCtx, cancel := c.requestContext(ctx)
+		request, err := newRequest(requestCtx)
+		if err != nil {
+			cancel()
+			return nil, nil, false, errors.NewInternalError(correlationId, "INVALID_REQUEST", "Failed to create request to "+c.url).
+				WithCause(err)
+		}
+
+		response, body, err := doRequest(client, request)
+		cancel()
+		if err == nil && !isRetryableStatus(response.StatusCode) {
+			return response, body, attempt > 0, nil
+		}
+		if attempt >= c.retries || ctx.Err() != nil {
+			if err == nil {
+				return response, body, attempt > 0, nil
+			}
+			return nil, nil, false, errors.NewConnectionError(
+				correlationId,
+				"CONNECT_FAILED",
+				"Failed to send request to "+c.url).
+				WithDetails("attempts", attempt+1).
+				WithCause(err)
+		}
+
+		wait := pause
+		if err == nil {
+			if after, ok := retryAfter(response); ok {
+				if after > c.maxRetryAfter() {
+					// Waiting that long would block the persistence, so the request fails
+					return response, body, attempt > 0, nil
+				}
+				wait = after
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+		pause *= 2
+	}
+}
+
+// maxRetryAfter gets the longest pause requested by Retry-After header that is waited for.
+// It is the timeout of requests or the longest backoff pause when the timeout is not set.
+func (c *HttpPersister[T]) maxRetryAfter() time.Duration {
+	if c.timeout > 0 {
+		return c.timeout
+	}
+	return c.retryTimeout << c.retries
+}
+
+// requestContext creates a context of a single request limited by the timeout
+func (c *HttpPersister[T]) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout > 0 {
+		return context.WithTimeout(ctx, c.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// doRequest sends a single request and reads its body
+func doRequest(client *http.Client, request *http.Request) (*http.Response, []byte, error) {
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return response, body, nil
+}
+
+// isSaved checks if the remote data already contains the saved body,
+// when the response to an earlier attempt of the save was lost
+func (c *HttpPersister[T]) isSaved(ctx context.Context, correlationId string, body []byte) bool {
+	response, remote, _, err := c.send(ctx, correlationId, func(ctx context.Context) (*http.Request, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Accept", "application/json")
+		return request, nil
+	})
+	if err != nil || response.StatusCode != http.StatusOK ||
+		!bytes.Equal(bytes.TrimSpace(remote), bytes.TrimSpace(body)) {
+		return false
+	}
+
+	c.etag = response.Header.Get("ETag")
+	c.body = body
+	return true
+}
+
+// retryAfter gets the pause requested by Retry-After header
+// in seconds or as HTTP date
+func retryAfter(response *http.Response) (time.Duration, bool) {
+	value := response.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		pause := time.Until(date)
+		if pause < 0 {
+			pause = 0
+		}
+		return pause, true
+	}
+	return 0, false
+}
+
+func isRetryableStatus(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
+
+func (c *HttpPersister[T]) checkUrl(correlationId string) error {
+	if c.url == "" {
+		return errors.NewConfigError(correlationId, "NO_URL", "Data URL is not set")
+	}
+	return nil
+}
+
+func (c *HttpPersister[T]) statusError(correlationId string, response *http.Response) error {
+	return errors.NewInvocationError(
+		correlationId,
+		"HTTP_ERROR",
+		"Request to "+c.url+" failed with status "+strconv.Itoa(response.StatusCode)).
+		WithDetails("url", c.url).
+		WithDetails("status", response.StatusCode)
+}
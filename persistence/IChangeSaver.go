@@ -0,0 +1,38 @@
+package persistence
+
+import "context"
+
+// ChangeSet contains changes of data items made since the previous save.
+// Every item appears in the change set only once with its latest state.
+//	Typed params:
+//		- T any type of getting element
+type ChangeSet[T any] struct {
+	// Inserted contains items that were created
+	Inserted []T
+	// Updated contains new values of items that were changed
+	Updated []T
+	// Deleted contains ids of items that were deleted
+	Deleted []any
+}
+
+// IsEmpty checks if the change set contains no changes.
+//	Returns: true if there are no changes and false otherwise.
+func (c *ChangeSet[T]) IsEmpty() bool {
+	return len(c.Inserted) == 0 && len(c.Updated) == 0 && len(c.Deleted) == 0
+}
+
+// IChangeSaver interface for data processing components that save changes incrementally.
+// MemoryPersistence calls it instead of ISaver when the configured saver implements it,
+// so only changed items are written to the data source.
+//	Typed params:
+//		- T any type of getting element
+type IChangeSaver[T any] interface {
+
+	// SaveChanges saves inserted, updated and deleted items.
+	//	Parameters:
+	//		- ctx context.Context	operation context
+	//		- correlationId string transaction id to trace execution through call chain.
+	//		- changes ChangeSet[T] changes to save.
+	//	Returns: error or nil for success.
+	SaveChanges(ctx context.Context, correlationId string, changes ChangeSet[T]) error
+}
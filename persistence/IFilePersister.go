@@ -0,0 +1,35 @@
+package persistence
+
+import (
+	"reflect"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
+)
+
+// IFilePersister interface for persister components that load and save data from/to flat files.
+// It is implemented by JsonFilePersister, YamlFilePersister, CsvFilePersister,
+// NdjsonFilePersister, GobFilePersister and other file persisters
+// and used by FilePersistence and IdentifiableFilePersistence.
+//	Typed params:
+//		- T any type of getting element
+type IFilePersister[T any] interface {
+	ILoader[T]
+	ISaver[T]
+	config.IConfigurable
+
+	// Path gets the file path where data is stored.
+	Path() string
+
+	// SetPath sets the file path where data is stored.
+	SetPath(value string)
+}
+
+// isNilPersister checks if the persister is nil, including a typed nil pointer
+// like (*JsonFilePersister[T])(nil) passed as IFilePersister
+func isNilPersister[T any](persister IFilePersister[T]) bool {
+	if persister == nil {
+		return true
+	}
+	value := reflect.ValueOf(persister)
+	return value.Kind() == reflect.Pointer && value.IsNil()
+}
@@ -0,0 +1,25 @@
+package persistence
+
+// IIdGenerator interface for components that generate unique ids for new data items.
+//	Typed params:
+//		- K any type of id (key)
+type IIdGenerator[K any] interface {
+
+	// NextId generates a new unique id.
+	//	Returns: K generated id
+	NextId() K
+}
+
+// IIdSequence interface for id generators that keep their state
+// and shall continue after ids that already exist in the persistence.
+// Identifiable persistences pass all loaded ids to the generator when they are opened.
+//	Typed params:
+//		- K any type of id (key)
+type IIdSequence[K any] interface {
+	IIdGenerator[K]
+
+	// Observe an existing id to ensure that it will never be generated again.
+	//	Parameters:
+	//		- id K an existing id
+	Observe(id K)
+}
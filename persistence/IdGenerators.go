@@ -0,0 +1,294 @@
+package persistence
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+)
+
+const (
+	// IdGeneratorLong generates 32-character hex strings
+	IdGeneratorLong = "long"
+	// IdGeneratorUuid generates random UUIDs (version 4)
+	IdGeneratorUuid = "uuid"
+	// IdGeneratorUuidV7 generates time ordered UUIDs (version 7)
+	IdGeneratorUuidV7 = "uuidv7"
+	// IdGeneratorUlid generates ULIDs
+	IdGeneratorUlid = "ulid"
+	// IdGeneratorSequence generates monotonic integer ids
+	IdGeneratorSequence = "sequence"
+)
+
+// NewIdGenerator creates an id generator by its name.
+//	Typed params:
+//		- K any type of id (key). String generators require string kind,
+//			sequence generator requires integer kind.
+//	Parameters:
+//		- name string a name of generator: "long", "uuid", "uuidv7", "ulid" or "sequence"
+//	Returns: IIdGenerator[K], error created generator or error when name or id type is not supported.
+func NewIdGenerator[K any](name string) (IIdGenerator[K], error) {
+	kind := idKind[K]()
+	name = strings.ToLower(strings.TrimSpace(name))
+
+	if name == IdGeneratorSequence {
+		if !isIntegerKind(kind) {
+			return nil, errors.NewConfigError("", "WRONG_ID_TYPE",
+				"Sequence id generator requires integer ids").
+				WithDetails("generator", name)
+		}
+		return NewSequenceIdGenerator[K](0), nil
+	}
+
+	if kind != reflect.String {
+		return nil, errors.NewConfigError("", "WRONG_ID_TYPE",
+			"Id generator "+name+" requires string ids").
+			WithDetails("generator", name)
+	}
+
+	switch name {
+	case IdGeneratorLong:
+		return NewLongIdGenerator[K](), nil
+	case IdGeneratorUuid:
+		return NewUuidIdGenerator[K](), nil
+	case IdGeneratorUuidV7:
+		return NewUuidV7IdGenerator[K](), nil
+	case IdGeneratorUlid:
+		return NewUlidIdGenerator[K](), nil
+	}
+
+	return nil, errors.NewConfigError("", "UNKNOWN_ID_GENERATOR",
+		"Unknown id generator "+name).
+		WithDetails("generator", name)
+}
+
+// defaultIdGenerator chooses generator for new persistences:
+// sequence for integer ids, long hex strings for string ids
+// and nil for other types of ids.
+func defaultIdGenerator[K any]() IIdGenerator[K] {
+	kind := idKind[K]()
+	if isIntegerKind(kind) {
+		return NewSequenceIdGenerator[K](0)
+	}
+	if kind == reflect.String {
+		return NewLongIdGenerator[K]()
+	}
+	return nil
+}
+
+func idKind[K any]() reflect.Kind {
+	return reflect.TypeOf((*K)(nil)).Elem().Kind()
+}
+
+func isIntegerKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// convertId converts a generated value into the id type
+func convertId[K any](value any) K {
+	if id, ok := value.(K); ok {
+		return id
+	}
+	typ := reflect.TypeOf((*K)(nil)).Elem()
+	return reflect.ValueOf(value).Convert(typ).Interface().(K)
+}
+
+// LongIdGenerator generates globally unique 32-character hex strings.
+// That is the same format as cdata.IdGenerator.NextLong.
+//	Typed params:
+//		- K any type of id with string kind
+type LongIdGenerator[K any] struct{}
+
+// NewLongIdGenerator creates a new instance of the generator.
+//	Returns: *LongIdGenerator[K]
+func NewLongIdGenerator[K any]() *LongIdGenerator[K] {
+	return &LongIdGenerator[K]{}
+}
+
+// NextId generates a new unique id.
+//	Returns: K generated id
+func (c *LongIdGenerator[K]) NextId() K {
+	value := uuid.New()
+	return convertId[K](hex.EncodeToString(value[:]))
+}
+
+// UuidIdGenerator generates random UUIDs (version 4) in canonical form.
+//	Typed params:
+//		- K any type of id with string kind
+type UuidIdGenerator[K any] struct{}
+
+// NewUuidIdGenerator creates a new instance of the generator.
+//	Returns: *UuidIdGenerator[K]
+func NewUuidIdGenerator[K any]() *UuidIdGenerator[K] {
+	return &UuidIdGenerator[K]{}
+}
+
+// NextId generates a new unique id.
+//	Returns: K generated id
+func (c *UuidIdGenerator[K]) NextId() K {
+	return convertId[K](uuid.NewString())
+}
+
+// UuidV7IdGenerator generates time ordered UUIDs (version 7) in canonical form.
+//	Typed params:
+//		- K any type of id with string kind
+type UuidV7IdGenerator[K any] struct{}
+
+// NewUuidV7IdGenerator creates a new instance of the generator.
+//	Returns: *UuidV7IdGenerator[K]
+func NewUuidV7IdGenerator[K any]() *UuidV7IdGenerator[K] {
+	return &UuidV7IdGenerator[K]{}
+}
+
+// NextId generates a new unique id.
+//	Returns: K generated id
+func (c *UuidV7IdGenerator[K]) NextId() K {
+	var value uuid.UUID
+	_, _ = rand.Read(value[6:])
+
+	ms := uint64(time.Now().UnixMilli())
+	value[0] = byte(ms >> 40)
+	value[1] = byte(ms >> 32)
+	value[2] = byte(ms >> 24)
+	value[3] = byte(ms >> 16)
+	value[4] = byte(ms >> 8)
+	value[5] = byte(ms)
+	value[6] = value[6]&0x0f | 0x70 // version 7
+	value[8] = value[8]&0x3f | 0x80 // RFC 4122 variant
+
+	return convertId[K](value.String())
+}
+
+const ulidEncoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// UlidIdGenerator generates lexicographically sortable ULIDs.
+// Ids generated within the same millisecond are monotonically increasing.
+//	Typed params:
+//		- K any type of id with string kind
+type UlidIdGenerator[K any] struct {
+	mtx     sync.Mutex
+	lastMs  uint64
+	entropy [10]byte
+}
+
+// NewUlidIdGenerator creates a new instance of the generator.
+//	Returns: *UlidIdGenerator[K]
+func NewUlidIdGenerator[K any]() *UlidIdGenerator[K] {
+	return &UlidIdGenerator[K]{}
+}
+
+// NextId generates a new unique id.
+//	Returns: K generated id
+func (c *UlidIdGenerator[K]) NextId() K {
+	c.mtx.Lock()
+	ms := uint64(time.Now().UnixMilli())
+	if ms > c.lastMs {
+		c.lastMs = ms
+		_, _ = rand.Read(c.entropy[:])
+	} else {
+		// Increment entropy to keep ids ordered within the same millisecond
+		for i := len(c.entropy) - 1; i >= 0; i-- {
+			c.entropy[i]++
+			if c.entropy[i] != 0 {
+				break
+			}
+		}
+	}
+	var value [16]byte
+	binary.BigEndian.PutUint16(value[0:2], uint16(c.lastMs>>32))
+	binary.BigEndian.PutUint32(value[2:6], uint32(c.lastMs))
+	copy(value[6:], c.entropy[:])
+	c.mtx.Unlock()
+
+	return convertId[K](encodeUlid(value))
+}
+
+// encodeUlid encodes 128 bits into 26 characters of Crockford's base32
+func encodeUlid(value [16]byte) string {
+	result := make([]byte, 26)
+	// The first character holds the 3 highest bits, every next one holds 5 bits
+	var bits uint
+	var buffer uint32
+	index := 25
+	for i := len(value) - 1; i >= 0; i-- {
+		buffer |= uint32(value[i]) << bits
+		bits += 8
+		for bits >= 5 {
+			result[index] = ulidEncoding[buffer&0x1f]
+			index--
+			buffer >>= 5
+			bits -= 5
+		}
+	}
+	result[0] = ulidEncoding[buffer&0x1f]
+	return string(result)
+}
+
+// SequenceIdGenerator generates monotonic integer ids.
+// The generator observes ids loaded by the persistence,
+// so the sequence continues after persistence is reopened.
+//	Important: this component is thread save!
+//	Typed params:
+//		- K any type of id with integer kind
+type SequenceIdGenerator[K any] struct {
+	mtx  sync.Mutex
+	last int64
+}
+
+// NewSequenceIdGenerator creates a new instance of the generator.
+//	Parameters:
+//		- start int64 the last used value, the first generated id is start + 1
+//	Returns: *SequenceIdGenerator[K]
+func NewSequenceIdGenerator[K any](start int64) *SequenceIdGenerator[K] {
+	return &SequenceIdGenerator[K]{last: start}
+}
+
+// NextId generates a new unique id.
+//	Returns: K generated id
+func (c *SequenceIdGenerator[K]) NextId() K {
+	c.mtx.Lock()
+	c.last++
+	value := c.last
+	c.mtx.Unlock()
+
+	return convertId[K](value)
+}
+
+// Observe an existing id to ensure that it will never be generated again.
+// Unsigned ids above math.MaxInt64 are out of the sequence range and can't collide with it,
+// so they are ignored.
+//	Parameters:
+//		- id K an existing id
+func (c *SequenceIdGenerator[K]) Observe(id K) {
+	var value int64
+	val := reflect.ValueOf(id)
+	switch {
+	case val.CanInt():
+		value = val.Int()
+	case val.CanUint():
+		if val.Uint() > math.MaxInt64 {
+			return
+		}
+		value = int64(val.Uint())
+	default:
+		return
+	}
+
+	c.mtx.Lock()
+	if value > c.last {
+		c.last = value
+	}
+	c.mtx.Unlock()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/refer"
 )
 
 // IdentifiableFilePersistence is an abstract persistence component that stores data in flat files
@@ -22,6 +23,7 @@ import (
 //		- the data items must implement IDataObject interface
 //
 //	see JsonFilePersister
+//	see YamlFilePersister
 //	see MemoryPersistence
 //
 //	Configuration parameters:
@@ -88,7 +90,7 @@ import (
 //
 type IdentifiableFilePersistence[T any, K any] struct {
 	*IdentifiableMemoryPersistence[T, K]
-	Persister *JsonFilePersister[T]
+	Persister IFilePersister[T]
 }
 
 // NewIdentifiableFilePersistence creates a new instance of the persistence.
@@ -97,11 +99,13 @@ type IdentifiableFilePersistence[T any, K any] struct {
 //			IDataObject interface of getting element
 //		- K any type if id (key)
 //	Parameters:
-//		- persister (optional) a persister component that loads and saves data from/to flat file.
+//		- persister (optional) a persister component that loads and saves data from/to flat file,
+//			for instance JsonFilePersister or YamlFilePersister. JsonFilePersister is used
+//			when the persister is nil or a nil pointer.
 //	Returns: *IdentifiableFilePersistence pointer on new IdentifiableFilePersistence
-func NewIdentifiableFilePersistence[T any, K any](persister *JsonFilePersister[T]) *IdentifiableFilePersistence[T, K] {
+func NewIdentifiableFilePersistence[T any, K any](persister IFilePersister[T]) *IdentifiableFilePersistence[T, K] {
 	c := &IdentifiableFilePersistence[T, K]{}
-	if persister == nil {
+	if isNilPersister(persister) {
 		persister = NewJsonFilePersister[T]("")
 	}
 	c.IdentifiableMemoryPersistence = NewIdentifiableMemoryPersistence[T, K]()
@@ -116,5 +120,18 @@ func NewIdentifiableFilePersistence[T any, K any](persister *JsonFilePersister[T
 //		- ctx context.Context
 //		- config *config.ConfigParams configuration parameters to be set.
 func (c *IdentifiableFilePersistence[T, K]) Configure(ctx context.Context, config *config.ConfigParams) {
+	c.IdentifiableMemoryPersistence.Configure(ctx, config)
 	c.Persister.Configure(ctx, config)
 }
+
+// SetReferences sets references to dependent components.
+// The references are also passed to the persister when it needs them.
+//	Parameters:
+//		- ctx context.Context
+//		- references refer.IReferences references to locate the component dependencies.
+func (c *IdentifiableFilePersistence[T, K]) SetReferences(ctx context.Context, references refer.IReferences) {
+	c.IdentifiableMemoryPersistence.SetReferences(ctx, references)
+	if referenceable, ok := c.Persister.(refer.IReferenceable); ok {
+		referenceable.SetReferences(ctx, references)
+	}
+}
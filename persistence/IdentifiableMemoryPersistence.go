@@ -2,12 +2,15 @@ package persistence
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"reflect"
-	"sync"
 
 	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
 	cdata "github.com/pip-services3-gox/pip-services3-commons-gox/data"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
 	refl "github.com/pip-services3-gox/pip-services3-commons-gox/reflect"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/validate"
 	"github.com/pip-services3-gox/pip-services3-components-gox/log"
 )
 
@@ -21,6 +24,17 @@ import (
 // In complex scenarios' child structs can implement additional operations by
 // accessing cached items via c.Items property and calling Save method
 // on updates.
+//
+// When Schema is set, items are validated in Create, Update, Set and UpdatePartially
+// (after merging the fields) and a validation error with all violations is returned
+// before anything is stored or saved.
+//
+// Ids for new items are generated by IdGenerator. By default string ids are generated
+// as 32-character hex strings and integer ids as a monotonic sequence
+// that continues after the ids loaded when the persistence is opened.
+//
+// By default GetOneById, Update, UpdatePartially and DeleteById return an empty value
+// and no error when the item is not found. In strict mode they return NotFoundError instead.
 //	Important:
 //		- this component is a thread save!
 //		- the data items must implement IDataObject interface
@@ -30,6 +44,15 @@ import (
 //	Configuration parameters:
 //		- options
 //		- max_page_size maximum number of items returned in a single page (default: 100)
+//		- max_items maximum number of stored items, 0 for unlimited (default: 0)
+//		- max_bytes approximate maximum size of stored items in bytes, 0 for unlimited (default: 0)
+//		- eviction_policy policy to evict items: "lru", "lfu" or "fifo" (default: "lru")
+//		- id_generator generator of ids for new items: "long", "uuid", "uuidv7", "ulid" or "sequence", other values fail Open
+//		- strict true to return NotFoundError when the item with requested id doesn't exist (default: false)
+//		- rollback_on_save_error true to revert in-memory changes when saving fails (default: false)
+//		- flush_interval interval in milliseconds to save changes in write-behind mode, 0 to disable (default: 0)
+//		- max_dirty number of pending changes that triggers saving in write-behind mode, 0 to disable (default: 0)
+//		- watch_interval interval in milliseconds to check the data file for external changes, 0 to disable (default: 0)
 //	References:
 //		- *:logger:*:*:1.0 (optional) ILogger components to pass log messages
 //	Typed params:
@@ -87,10 +110,21 @@ import (
 //	Implements: IConfigurable, IWriter, IGetter, ISetter
 type IdentifiableMemoryPersistence[T any, K any] struct {
 	*MemoryPersistence[T]
-	Mtx sync.RWMutex
+	// Schema (optional) to validate items before they are stored
+	Schema validate.ISchema
+	// IdGenerator generates ids for new items with empty ids
+	IdGenerator IIdGenerator[K]
+	// Strict enables returning NotFoundError for missing items
+	Strict bool
+	// configErr is an error of invalid configuration returned on Open
+	configErr error
 }
 
-const IdentifiableMemoryPersistenceConfigParamOptionsMaxPageSize = "options.max_page_size"
+const (
+	IdentifiableMemoryPersistenceConfigParamOptionsMaxPageSize = "options.max_page_size"
+	IdentifiableMemoryPersistenceConfigParamOptionsIdGenerator = "options.id_generator"
+	IdentifiableMemoryPersistenceConfigParamOptionsStrict      = "options.strict"
+)
 
 // NewIdentifiableMemoryPersistence creates a new empty instance of the persistence.
 //	Typed params:
@@ -101,7 +135,15 @@ const IdentifiableMemoryPersistenceConfigParamOptionsMaxPageSize = "options.max_
 func NewIdentifiableMemoryPersistence[T any, K any]() (c *IdentifiableMemoryPersistence[T, K]) {
 	c = &IdentifiableMemoryPersistence[T, K]{
 		MemoryPersistence: NewMemoryPersistence[T](),
+		IdGenerator:       defaultIdGenerator[K](),
 	}
+	c.changes = newChangeTracker[T](func(item T) any {
+		return c.getItemId(item)
+	})
+	// Reloaded items may contain ids that are not known to the sequence yet
+	c.AddReloadListener(func(ctx context.Context, correlationId string) {
+		c.observeIds()
+	})
 	c.Logger = log.NewCompositeLogger()
 	c.MaxPageSize = 100
 	return c
@@ -112,7 +154,85 @@ func NewIdentifiableMemoryPersistence[T any, K any]() (c *IdentifiableMemoryPers
 //		- ctx context.Context	operation context
 //		- config *config.ConfigParams configuration parameters to be set.
 func (c *IdentifiableMemoryPersistence[T, K]) Configure(ctx context.Context, config *config.ConfigParams) {
+	c.MemoryPersistence.Configure(ctx, config)
 	c.MaxPageSize = config.GetAsIntegerWithDefault(IdentifiableMemoryPersistenceConfigParamOptionsMaxPageSize, c.MaxPageSize)
+	c.Strict = config.GetAsBooleanWithDefault(IdentifiableMemoryPersistenceConfigParamOptionsStrict, c.Strict)
+
+	if name, ok := config.GetAsNullableString(IdentifiableMemoryPersistenceConfigParamOptionsIdGenerator); ok && name != "" {
+		generator, err := NewIdGenerator[K](name)
+		c.configErr = err
+		if err != nil {
+			c.Logger.Error(ctx, "", err, "Failed to configure id generator")
+		} else {
+			c.IdGenerator = generator
+		}
+	}
+}
+
+// Open the component and passes ids of loaded items to IdGenerator
+// when it implements IIdSequence interface.
+// Invalid configuration, like unknown id generator, is returned as ConfigError.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId  string (optional) transaction id to trace execution through call chain.
+//	Returns: error or null no errors occurred.
+func (c *IdentifiableMemoryPersistence[T, K]) Open(ctx context.Context, correlationId string) error {
+	if c.configErr != nil {
+		return c.configErr
+	}
+	if err := c.MemoryPersistence.Open(ctx, correlationId); err != nil {
+		return err
+	}
+
+	c.observeIds()
+	return nil
+}
+
+// observeIds passes ids of stored items to IdGenerator when it implements IIdSequence interface
+func (c *IdentifiableMemoryPersistence[T, K]) observeIds() {
+	if sequence, ok := c.IdGenerator.(IIdSequence[K]); ok {
+		c.Mtx.RLock()
+		for _, item := range c.Items {
+			sequence.Observe(c.getItemId(item))
+		}
+		c.Mtx.RUnlock()
+	}
+}
+
+// observeId passes an explicit id of a written item to IdGenerator when it implements
+// IIdSequence interface, so generated ids never repeat it. Must be called under write lock.
+func (c *IdentifiableMemoryPersistence[T, K]) observeId(id K) {
+	if sequence, ok := c.IdGenerator.(IIdSequence[K]); ok && !c.isEmptyId(id) {
+		sequence.Observe(id)
+	}
+}
+
+// Import reads items in a given format and stores them according to import mode
+// the same way as MemoryPersistence.Import. Ids of items without ids are generated by IdGenerator
+// and items are validated as in Create. Records that fail validation are counted in the report.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string (optional) transaction id to trace execution through call chain.
+//		- reader io.Reader a source of imported data
+//		- format DataFormat a format of imported data
+//		- mode ImportMode how imported items are combined with stored items
+//	Returns: ImportReport, error the import report or error.
+func (c *IdentifiableMemoryPersistence[T, K]) Import(ctx context.Context, correlationId string,
+	reader io.Reader, format DataFormat, mode ImportMode) (ImportReport, error) {
+
+	sequence, _ := c.IdGenerator.(IIdSequence[K])
+	report, err := c.importItems(ctx, correlationId, reader, format, mode, func(item T) (T, error) {
+		id := c.getItemId(item)
+		if sequence != nil && !c.isEmptyId(id) {
+			// Generated ids of next records don't repeat imported ones
+			sequence.Observe(id)
+		}
+		if _item, ok := c.setItemId(item, id).(T); ok {
+			item = _item
+		}
+		return item, c.checkItem(correlationId, item)
+	})
+	return report, err
 }
 
 // GetListByIds gets a list of data items retrieved by given unique ids.
@@ -147,9 +267,10 @@ func (c *IdentifiableMemoryPersistence[T, K]) GetOneById(ctx context.Context, co
 	c.Mtx.RLock()
 	defer c.Mtx.RUnlock()
 
-	for _, item := range c.Items {
+	for i, item := range c.Items {
 		itemId := c.getItemId(item)
 		if c.isEqualIds(itemId, id) {
+			c.touchItem(i)
 			c.Logger.Trace(ctx, correlationId, "Retrieved item %s", id)
 			return c.cloneItem(item), nil
 		}
@@ -158,7 +279,7 @@ func (c *IdentifiableMemoryPersistence[T, K]) GetOneById(ctx context.Context, co
 	c.Logger.Trace(ctx, correlationId, "Cannot find item by %s", id)
 
 	var defaultObject T
-	return defaultObject, nil
+	return defaultObject, c.notFoundError(correlationId, id)
 }
 
 // GetIndexById get index by "Id" field
@@ -169,6 +290,13 @@ func (c *IdentifiableMemoryPersistence[T, K]) GetIndexById(id K) int {
 	c.Mtx.RLock()
 	defer c.Mtx.RUnlock()
 
+	return c.indexOfId(id)
+}
+
+// indexOfId finds index of an item by its id. It must be called under the lock
+// that is held until the index is used, because Create, eviction and reload shift the items.
+//	Returns: index number or -1 when the item is not found
+func (c *IdentifiableMemoryPersistence[T, K]) indexOfId(id K) int {
 	for i, item := range c.Items {
 		if c.isEqualIds(c.getItemId(item), id) {
 			return i
@@ -184,19 +312,28 @@ func (c *IdentifiableMemoryPersistence[T, K]) GetIndexById(id K) int {
 //		- item T an item to be created.
 //	Returns: T, error created item or error.
 func (c *IdentifiableMemoryPersistence[T, K]) Create(ctx context.Context, correlationId string, item T) (T, error) {
-	c.Mtx.Lock()
-
 	newItem := c.cloneItem(item)
 	if _item, ok := c.setItemId(newItem, c.getItemId(newItem)).(T); ok {
 		newItem = _item
 	}
 
+	if err := c.checkItem(correlationId, newItem); err != nil {
+		var defaultObject T
+		return defaultObject, err
+	}
+
+	c.Mtx.Lock()
+	c.observeId(c.getItemId(item))
+	snapshot := c.takeSnapshot()
+
 	c.Items = append(c.Items, newItem)
+	c.tracker.insert()
+	c.recordInsert(newItem)
+	evicted := c.evictItems(len(c.Items) - 1)
 
-	c.Mtx.Unlock()
 	c.Logger.Trace(ctx, correlationId, "Created item %s", c.getItemId(newItem))
 
-	if err := c.Save(ctx, correlationId); err != nil {
+	if err := c.completeWrite(ctx, correlationId, &c.Mtx, snapshot, evicted); err != nil {
 		return c.cloneItem(newItem), err
 	}
 
@@ -216,19 +353,30 @@ func (c *IdentifiableMemoryPersistence[T, K]) Set(ctx context.Context, correlati
 		newItem = _item
 	}
 
-	index := c.GetIndexById(c.getItemId(item))
+	if err := c.checkItem(correlationId, newItem); err != nil {
+		var defaultObject T
+		return defaultObject, err
+	}
 
 	c.Mtx.Lock()
+	c.observeId(c.getItemId(item))
+	snapshot := c.takeSnapshot()
+	index := c.indexOfId(c.getItemId(newItem))
 	if index < 0 {
 		c.Items = append(c.Items, newItem)
+		c.tracker.insert()
+		c.recordInsert(newItem)
+		index = len(c.Items) - 1
 	} else {
 		c.Items[index] = newItem
+		c.tracker.update(index)
+		c.recordUpdate(newItem)
 	}
+	evicted := c.evictItems(index)
 
-	c.Mtx.Unlock()
 	c.Logger.Trace(ctx, correlationId, "Set item %s", c.getItemId(newItem))
 
-	if err := c.Save(ctx, correlationId); err != nil {
+	if err := c.completeWrite(ctx, correlationId, &c.Mtx, snapshot, evicted); err != nil {
 		return c.cloneItem(newItem), err
 	}
 
@@ -244,20 +392,28 @@ func (c *IdentifiableMemoryPersistence[T, K]) Set(ctx context.Context, correlati
 func (c *IdentifiableMemoryPersistence[T, K]) Update(ctx context.Context, correlationId string, item T) (T, error) {
 	var defaultObject T
 
-	index := c.GetIndexById(c.getItemId(item))
+	newItem := c.cloneItem(item)
+	if err := c.checkItem(correlationId, newItem); err != nil {
+		return defaultObject, err
+	}
+
+	c.Mtx.Lock()
+	index := c.indexOfId(c.getItemId(item))
 	if index < 0 {
+		c.Mtx.Unlock()
 		c.Logger.Trace(ctx, correlationId, "Item %s was not found", c.getItemId(item))
-		return defaultObject, nil
+		return defaultObject, c.notFoundError(correlationId, c.getItemId(item))
 	}
-	newItem := c.cloneItem(item)
 
-	c.Mtx.Lock()
+	snapshot := c.takeSnapshot()
 	c.Items[index] = newItem
-	c.Mtx.Unlock()
+	c.tracker.update(index)
+	c.recordUpdate(newItem)
+	evicted := c.evictItems(index)
 
 	c.Logger.Trace(ctx, correlationId, "Updated item %s", c.getItemId(item))
 
-	if err := c.Save(ctx, correlationId); err != nil {
+	if err := c.completeWrite(ctx, correlationId, &c.Mtx, snapshot, evicted); err != nil {
 		return c.cloneItem(newItem), err
 	}
 
@@ -276,14 +432,15 @@ func (c *IdentifiableMemoryPersistence[T, K]) UpdatePartially(ctx context.Contex
 
 	var defaultObject T
 
-	index := c.GetIndexById(id)
+	c.Mtx.Lock()
+
+	index := c.indexOfId(id)
 	if index < 0 {
+		c.Mtx.Unlock()
 		c.Logger.Trace(ctx, correlationId, "Item %s was not found", id)
-		return defaultObject, nil
+		return defaultObject, c.notFoundError(correlationId, id)
 	}
 
-	c.Mtx.Lock()
-
 	newItem := c.cloneItem(c.Items[index])
 
 	if reflect.ValueOf(newItem).Kind() == reflect.Map {
@@ -301,18 +458,100 @@ func (c *IdentifiableMemoryPersistence[T, K]) UpdatePartially(ctx context.Contex
 		}
 	}
 
+	if err := c.checkItem(correlationId, newItem); err != nil {
+		c.Mtx.Unlock()
+		return defaultObject, err
+	}
+
+	snapshot := c.takeSnapshot()
 	c.Items[index] = newItem
+	c.tracker.update(index)
+	c.recordUpdate(newItem)
+	evicted := c.evictItems(index)
 
-	c.Mtx.Unlock()
 	c.Logger.Trace(ctx, correlationId, "Partially updated item %s", id)
 
-	if err := c.Save(ctx, correlationId); err != nil {
+	if err := c.completeWrite(ctx, correlationId, &c.Mtx, snapshot, evicted); err != nil {
 		return c.cloneItem(newItem), err
 	}
 
 	return c.cloneItem(newItem), nil
 }
 
+// ModifyById atomically modifies a data item based on its current value.
+// The modifier receives a copy of the current item and is executed under the write lock,
+// so no other changes can happen in between. A panic of the modifier releases the lock. The result is stored only when the modifier
+// returns no error. The modifier must not change the item id.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string (optional) transaction id to trace execution through call chain.
+//		- id K an id of data item to be modified.
+//		- modifier func(T) (T, error) a function that returns a modified item.
+//	Returns: T, T, error the item before and after modification or error.
+func (c *IdentifiableMemoryPersistence[T, K]) ModifyById(ctx context.Context, correlationId string,
+	id K, modifier func(T) (T, error)) (T, T, error) {
+
+	var defaultObject T
+
+	c.Mtx.Lock()
+
+	index := c.indexOfId(id)
+	if index < 0 {
+		c.Mtx.Unlock()
+		c.Logger.Trace(ctx, correlationId, "Item %s was not found", id)
+		return defaultObject, defaultObject, c.notFoundError(correlationId, id)
+	}
+
+	oldItem := c.Items[index]
+	newItem, err := c.applyModifier(modifier, oldItem)
+	if err != nil {
+		c.Mtx.Unlock()
+		return defaultObject, defaultObject, err
+	}
+
+	if !c.isEqualIds(c.getItemId(newItem), id) {
+		c.Mtx.Unlock()
+		return defaultObject, defaultObject, errors.NewBadRequestError(
+			correlationId,
+			"ID_CHANGED",
+			fmt.Sprintf("Modifier changed id of item %v", id)).
+			WithDetails("id", id)
+	}
+
+	if err := c.checkItem(correlationId, newItem); err != nil {
+		c.Mtx.Unlock()
+		return defaultObject, defaultObject, err
+	}
+
+	newItem = c.cloneItem(newItem)
+	snapshot := c.takeSnapshot()
+	c.Items[index] = newItem
+	c.tracker.update(index)
+	c.recordUpdate(newItem)
+	evicted := c.evictItems(index)
+
+	c.Logger.Trace(ctx, correlationId, "Modified item %s", id)
+
+	if err := c.completeWrite(ctx, correlationId, &c.Mtx, snapshot, evicted); err != nil {
+		return c.cloneItem(oldItem), c.cloneItem(newItem), err
+	}
+
+	return c.cloneItem(oldItem), c.cloneItem(newItem), nil
+}
+
+// applyModifier calls the modifier on a copy of the item under the write lock.
+// When the modifier panics the lock is released before the panic goes on,
+// so the persistence is not locked forever.
+func (c *IdentifiableMemoryPersistence[T, K]) applyModifier(modifier func(T) (T, error), item T) (T, error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.Mtx.Unlock()
+			panic(r)
+		}
+	}()
+	return modifier(c.cloneItem(item))
+}
+
 // DeleteById a data item by it's unique id.
 //	Parameters:
 //		- ctx context.Context	operation context
@@ -323,26 +562,23 @@ func (c *IdentifiableMemoryPersistence[T, K]) DeleteById(ctx context.Context, co
 
 	var defaultObject T
 
-	index := c.GetIndexById(id)
+	c.Mtx.Lock()
+	index := c.indexOfId(id)
 	if index < 0 {
+		c.Mtx.Unlock()
 		c.Logger.Trace(ctx, correlationId, "Item %s was not found", id)
-		return defaultObject, nil
+		return defaultObject, c.notFoundError(correlationId, id)
 	}
 
-	c.Mtx.Lock()
+	snapshot := c.takeSnapshot()
 
 	oldItem := c.Items[index]
-	if index == len(c.Items) {
-		c.Items = c.Items[:index-1]
-	} else {
-		c.Items = append(c.Items[:index], c.Items[index+1:]...)
-	}
-
-	c.Mtx.Unlock()
+	c.recordDelete(oldItem)
+	c.removeItemAt(index)
 
 	c.Logger.Trace(ctx, correlationId, "Deleted item by %s", id)
 
-	if err := c.Save(ctx, correlationId); err != nil {
+	if err := c.completeWrite(ctx, correlationId, &c.Mtx, snapshot, nil); err != nil {
 		return oldItem, err
 	}
 	return oldItem, nil
@@ -368,6 +604,44 @@ func (c *IdentifiableMemoryPersistence[T, K]) DeleteByIds(ctx context.Context, c
 	return c.DeleteByFilter(ctx, correlationId, filterFunc)
 }
 
+// notFoundError creates an error for missing item in strict mode.
+//	Returns: NotFoundError or nil when strict mode is disabled.
+func (c *IdentifiableMemoryPersistence[T, K]) notFoundError(correlationId string, id K) error {
+	if !c.Strict {
+		return nil
+	}
+
+	return errors.NewNotFoundError(
+		correlationId,
+		"ITEM_NOT_FOUND",
+		fmt.Sprintf("Item %v was not found", id)).
+		WithDetails("id", id)
+}
+
+// checkItem checks the item before it is stored: validates it against configured schema
+// and checks that it fits into the capacity of the persistence.
+//	Returns: validation error or nil when the item can be stored.
+func (c *IdentifiableMemoryPersistence[T, K]) checkItem(correlationId string, item T) error {
+	if err := c.validateItem(correlationId, item); err != nil {
+		return err
+	}
+	return c.checkItemSize(correlationId, item)
+}
+
+// validateItem checks the item against configured schema.
+//	Returns: validation error with all violations or nil when the item is valid or schema is not set.
+func (c *IdentifiableMemoryPersistence[T, K]) validateItem(correlationId string, item T) error {
+	if c.Schema == nil {
+		return nil
+	}
+	// ValidateAndReturnError returns *ApplicationError, so its nil result
+	// must not be returned as a non-nil error interface
+	if err := c.Schema.ValidateAndReturnError(correlationId, item, false); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (c *IdentifiableMemoryPersistence[T, K]) isEqualIds(idA, idB any) bool {
 	return CompareValues(idA, idB)
 }
@@ -388,7 +662,11 @@ func (c *IdentifiableMemoryPersistence[T, K]) getItemId(item any) K {
 func (c *IdentifiableMemoryPersistence[T, K]) setItemId(item any, id any) any {
 	newId := id
 	if c.isEmptyId(id) {
-		newId = cdata.IdGenerator.NextLong()
+		if c.IdGenerator != nil {
+			newId = c.IdGenerator.NextId()
+		} else {
+			newId = cdata.IdGenerator.NextLong()
+		}
 	}
 	SetObjectId(&item, newId)
 	return item
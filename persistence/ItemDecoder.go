@@ -0,0 +1,174 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/convert"
+)
+
+// itemDecoder reads data items from streams in JSON, NDJSON and CSV formats.
+// JSON is an array of items, NDJSON has one item per line and CSV has a header row
+// with names of fields and one item per row. Records that can't be converted
+// into items are passed to fail function, that decides if decoding continues.
+type itemDecoder[T any] struct {
+	convertor convert.IJSONEngine[T]
+	// fail is called for every invalid record with its number starting from 1.
+	// It returns error to stop decoding or nil to skip the record.
+	fail func(record int, message string, cause error) error
+	// readError wraps errors of reading and parsing the stream
+	readError func(err error) error
+	// prepare (optional) is called for every converted item.
+	// It returns the item to keep or error to pass the record to fail function.
+	prepare func(item T) (T, error)
+}
+
+// decode reads all items from the stream in a given format
+func (c *itemDecoder[T]) decode(format DataFormat, reader io.Reader) ([]T, error) {
+	switch format {
+	case DataFormatJson:
+		return c.decodeJson(reader)
+	case DataFormatNdjson:
+		return c.decodeNdjson(reader)
+	case DataFormatCsv:
+		return c.decodeCsv(reader)
+	default:
+		return nil, c.readError(fmt.Errorf("data format %s is not supported", format))
+	}
+}
+
+func (c *itemDecoder[T]) decodeJson(reader io.Reader) ([]T, error) {
+	decoder := json.NewDecoder(reader)
+	token, err := decoder.Token()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, c.readError(err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, c.readError(fmt.Errorf("JSON data must be an array of items"))
+	}
+
+	items := make([]T, 0)
+	for record := 1; decoder.More(); record++ {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, c.readError(err)
+		}
+		if err := c.appendItem(&items, raw, record); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, c.readError(err)
+	}
+	return items, nil
+}
+
+func (c *itemDecoder[T]) decodeNdjson(reader io.Reader) ([]T, error) {
+	r := bufio.NewReader(reader)
+	items := make([]T, 0)
+	for line := 1; ; line++ {
+		data, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, c.readError(err)
+		}
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			if err := c.appendItem(&items, data, line); err != nil {
+				return nil, err
+			}
+		}
+		if err == io.EOF {
+			return items, nil
+		}
+	}
+}
+
+func (c *itemDecoder[T]) decodeCsv(reader io.Reader) ([]T, error) {
+	r := csv.NewReader(reader)
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, c.readError(err)
+	}
+	columns := make([]string, len(header))
+	for i, column := range header {
+		columns[i] = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+	}
+
+	fields := csvFieldTypes(reflect.TypeOf((*T)(nil)).Elem())
+	items := make([]T, 0)
+	for row := 1; ; row++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, c.readError(err)
+		}
+
+		if len(record) > len(columns) {
+			if err := c.fail(row, "Too many values", nil); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		values := make(map[string]any, len(record))
+		var failure string
+		for i, cell := range record {
+			if cell == "" {
+				continue
+			}
+			value, ok := convertCsvValue(cell, fields[columns[i]])
+			if !ok {
+				failure = "Invalid value '" + cell + "' in column '" + columns[i] + "'"
+				break
+			}
+			values[columns[i]] = value
+		}
+		if failure != "" {
+			if err := c.fail(row, failure, nil); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		data, err := json.Marshal(values)
+		if err != nil {
+			if err := c.fail(row, "Failed to convert values", err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := c.appendItem(&items, data, row); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// appendItem converts a record into an item or passes it to fail function
+func (c *itemDecoder[T]) appendItem(items *[]T, data []byte, record int) error {
+	item, err := c.convertor.FromJson(string(data))
+	if err != nil {
+		return c.fail(record, "Failed to convert item", err)
+	}
+	if c.prepare != nil {
+		if item, err = c.prepare(item); err != nil {
+			return c.fail(record, "Invalid item", err)
+		}
+	}
+	*items = append(*items, item)
+	return nil
+}
@@ -2,19 +2,61 @@ package persistence
 
 import (
 	"context"
-	"io/ioutil"
-	"os"
+	"sync"
 
 	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
 	"github.com/pip-services3-gox/pip-services3-commons-gox/convert"
 	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/refer"
 )
 
 // JsonFilePersister is a persistence component that loads and saves data from/to flat file.
 // It is used by FilePersistence, but can be useful on its own.
+// Data is written into a temporary file, flushed to disk and atomically renamed
+// over the data file, so a crash during Save never leaves a truncated file.
+//
+// When lock option is enabled, processes that share the data file coordinate
+// through advisory locks: Load takes a shared lock and Save an exclusive one,
+// waiting for other processes up to lock_timeout. Save also checks that the file
+// was not changed by another process since the last Load or Save and returns
+// ConflictError "DATA_FILE_CHANGED" instead of overwriting the changes.
+// Load the file again, for instance by reopening the persistence or with
+// watch_interval option of MemoryPersistence, to resolve the conflict.
+//
+// Data files can be encrypted at rest with AES-256-GCM. The key is set by encryption_key
+// option or resolved from a credential, that allows to keep it in a credential store.
+// Files are always saved with the current key. To rotate the key set a new one and move
+// the previous key to old_encryption_keys: files encrypted with old keys are still loaded
+// and re-encrypted on the next Save. Damaged, modified, unencrypted files or files
+// encrypted with unknown key fail to load with FileError "DECRYPTION_FAILED".
+// To encrypt existing data set allow_plaintext option until the files are saved once.
+// The same options are supported by all file persisters.
+//
+// Data files can be upgraded as data structures evolve. Migrations registered
+// with AddMigration are applied on Load to raw items of previous versions,
+// then the file is rewritten in the latest version after it is read.
+// Migrations are supported only by JsonFilePersister and YamlFilePersister,
+// other file persisters store items without versioned envelope.
 //	Important: this component is not thread save!
 //	Configuration parameters:
 //		- path to the file where data is stored
+//		- options:
+//			- file_mode: octal permissions of the data file (default: "0644")
+//			- backups: number of previous copies kept as "<path>.1", "<path>.2", ... (default: 0)
+//			- compress: true to compress the data file with gzip on save, compressed files are detected on load (default: false)
+//			- encryption_key: AES-256 key to encrypt the data file as base64 or hex string (32 bytes)
+//			- old_encryption_keys: comma-separated list of previous keys to read files after key rotation
+//			- allow_plaintext: true to load unencrypted files when encryption key is set (default: false)
+//			- checksum: true to keep SHA-256 checksum of the data file in "<path>.sha256" and verify it on load (default: false)
+//			- on_corruption: "fail" to return an error or "recover" to load the newest valid backup when the checksum doesn't match (default: "fail")
+//			- lock: true to lock the data file and detect its external changes (default: false)
+//			- lock_timeout: time in milliseconds to wait for a lock held by another process (default: 10000)
+//		- credential:
+//			- store_key: (optional) a key to retrieve the encryption key from ICredentialStore
+//			- encryption_key: AES-256 key, an alternative to encryption_key option
+//	References:
+//		- *:logger:*:*:1.0 (optional) loggers to log warnings
+//		- *:credential_store:*:*:1.0 (optional) credential stores to resolve the encryption key
 //	Typed params:
 //		- T any type
 //	Example:
@@ -26,10 +68,28 @@ import (
 //				fmt.Println(items) // Result: ["A", "B", "C"]
 //			}
 //		}
-//	Implements: ILoader, ISaver, IConfigurable
+//
+//		// Version 1 renames "name" property to "title"
+//		persister.AddMigration(1, func(items []map[string]any) ([]map[string]any, error) {
+//			for _, item := range items {
+//				item["title"] = item["name"]
+//				delete(item, "name")
+//			}
+//			return items, nil
+//		})
+//	Implements: ILoader, ISaver, IConfigurable, IReferenceable
 type JsonFilePersister[T any] struct {
-	path      string
+	file      dataFile
 	convertor convert.IJSONEngine[[]T]
+
+	migrations dataMigrations
+
+	lock fileLock
+	// known is a state of the data file after the last Load or Save,
+	// it is guarded by own lock because concurrent loads share the file lock
+	trackMtx sync.Mutex
+	known    fileFingerprint
+	tracked  bool
 }
 
 const ConfigParamPath = "path"
@@ -40,22 +100,24 @@ const ConfigParamPath = "path"
 //	Parameters: path string (optional) a path to the file where data is stored.
 func NewJsonFilePersister[T any](path string) *JsonFilePersister[T] {
 	return &JsonFilePersister[T]{
-		path:      path,
+		file:      newDataFile(path),
 		convertor: convert.NewDefaultCustomTypeJsonConvertor[[]T](),
+		lock:      newFileLock(),
 	}
 }
 
 // Path gets the file path where data is stored.
 //	Returns: the file path where data is stored.
 func (c *JsonFilePersister[T]) Path() string {
-	return c.path
+	return c.file.path
 }
 
 // SetPath the file path where data is stored.
 //	Parameters:
 //		- value string the file path where data is stored.
 func (c *JsonFilePersister[T]) SetPath(value string) {
-	c.path = value
+	c.file.path = value
+	c.tracked = false
 }
 
 // Configure component by passing configuration parameters.
@@ -63,7 +125,20 @@ func (c *JsonFilePersister[T]) SetPath(value string) {
 //		- ctx context.Context	operation context
 //		- config: ConfigParams configuration parameters to be set.
 func (c *JsonFilePersister[T]) Configure(ctx context.Context, config *config.ConfigParams) {
-	c.path = config.GetAsStringWithDefault(ConfigParamPath, c.path)
+	path := c.file.path
+	c.file.configure(config)
+	c.lock.configure(config)
+	if c.file.path != path {
+		c.tracked = false
+	}
+}
+
+// SetReferences sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references refer.IReferences references to locate the component dependencies.
+func (c *JsonFilePersister[T]) SetReferences(ctx context.Context, references refer.IReferences) {
+	c.file.setReferences(ctx, references)
 }
 
 // Load data items from external JSON file.
@@ -72,32 +147,70 @@ func (c *JsonFilePersister[T]) Configure(ctx context.Context, config *config.Con
 //		- correlationId string transaction id to trace execution through call chain.
 // Returns: []T, error loaded items or error.
 func (c *JsonFilePersister[T]) Load(ctx context.Context, correlationId string) ([]T, error) {
-	if c.path == "" {
-		return nil, errors.NewConfigError("", "NO_PATH", "Data file path is not set")
+	jsonStr, err := c.readFile(correlationId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(jsonStr) == 0 {
+		return nil, nil
 	}
 
-	if _, err := os.Stat(c.path); os.IsNotExist(err) {
+	jsonStr, migrated, err := c.migrations.unwrap(correlationId, c.file.path, jsonStr)
+	if err != nil {
 		return nil, err
 	}
 
-	jsonStr, err := ioutil.ReadFile(c.path)
+	list, err := c.convertor.FromJson(string(jsonStr))
 	if err != nil {
-		return nil, errors.NewFileError(
-			correlationId,
-			"READ_FAILED",
-			"Failed to read data file: "+c.path).
-			WithCause(err)
+		return nil, err
 	}
 
-	if len(jsonStr) == 0 {
-		return nil, nil
+	if migrated {
+		c.rewrite(ctx, correlationId, list)
+	}
+	return list, nil
+}
+
+// rewrite saves migrated items in the latest version after the shared lock of Load is released.
+// Items are already loaded, so a failed rewrite is only logged and the file is migrated again next time.
+// The rewrite changes the data file, so it is seen by watchers of other processes.
+func (c *JsonFilePersister[T]) rewrite(ctx context.Context, correlationId string, items []T) {
+	if err := c.Save(ctx, correlationId, items); err != nil {
+		c.file.logger.Warn(ctx, correlationId,
+			"Failed to rewrite data file %s in version %d: %v", c.file.path, c.migrations.version, err)
 	}
+}
 
-	if list, err := c.convertor.FromJson(string(jsonStr)); err != nil {
+// AddMigration registers a function that upgrades data items loaded
+// from data files of the previous version to a given version.
+// When migrations are registered, data files are saved in versioned envelope
+// {"version": n, "items": [...]} where n is the highest registered version.
+// Data files without envelope are treated as version 0.
+//	Parameters:
+//		- version int a version of data items produced by the migration starting from 1
+//		- migration MigrationFunc a function that upgrades data items
+func (c *JsonFilePersister[T]) AddMigration(version int, migration MigrationFunc) {
+	c.migrations.add(version, migration)
+}
+
+// Version gets the latest version of data files.
+//	Returns: the highest registered migration version or 0.
+func (c *JsonFilePersister[T]) Version() int {
+	return c.migrations.version
+}
+
+// readFile reads the data file under shared lock
+func (c *JsonFilePersister[T]) readFile(correlationId string) ([]byte, error) {
+	lock, err := c.lock.lock(correlationId, c.file.path, c.file.mode, false)
+	if err != nil {
 		return nil, err
-	} else {
-		return list, nil
 	}
+	defer c.lock.unlock(lock)
+
+	data, err := c.file.read(correlationId)
+	c.track()
+	return data, err
 }
 
 // Save given data items to external JSON file.
@@ -112,12 +225,51 @@ func (c *JsonFilePersister[T]) Save(ctx context.Context, correlationId string, i
 		err := errors.NewInternalError(correlationId, "CAN'T_CONVERT", "Failed convert to JSON")
 		return err
 	}
-	if err := ioutil.WriteFile(c.path, ([]byte)(json), 0777); err != nil {
-		return errors.NewFileError(
+	json = c.migrations.wrap(json)
+
+	lock, err := c.lock.lock(correlationId, c.file.path, c.file.mode, true)
+	if err != nil {
+		return err
+	}
+	defer c.lock.unlock(lock)
+
+	if err := c.checkConflict(correlationId); err != nil {
+		return err
+	}
+	if err := c.file.write(correlationId, ([]byte)(json)); err != nil {
+		return err
+	}
+	c.track()
+	return nil
+}
+
+// track remembers the state of the data file to detect its external changes
+func (c *JsonFilePersister[T]) track() {
+	if c.lock.enabled && c.file.path != "" {
+		known := takeFingerprint(c.file.path, fileFingerprint{})
+		c.trackMtx.Lock()
+		c.known = known
+		c.tracked = true
+		c.trackMtx.Unlock()
+	}
+}
+
+// checkConflict returns ConflictError when the data file was changed
+// by another process since the last Load or Save
+func (c *JsonFilePersister[T]) checkConflict(correlationId string) error {
+	c.trackMtx.Lock()
+	known, tracked := c.known, c.tracked
+	c.trackMtx.Unlock()
+	if !c.lock.enabled || !tracked {
+		return nil
+	}
+
+	if takeFingerprint(c.file.path, known) != known {
+		return errors.NewConflictError(
 			correlationId,
-			"WRITE_FAILED",
-			"Failed to write data file: "+c.path).
-			WithCause(err)
+			"DATA_FILE_CHANGED",
+			"Data file was changed by another process since it was loaded: "+c.file.path).
+			WithDetails("path", c.file.path)
 	}
 	return nil
 }
@@ -0,0 +1,570 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/convert"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+)
+
+const (
+	// ConfigParamCompactThreshold is number of log records that triggers compaction
+	ConfigParamCompactThreshold = "options.compact_threshold"
+
+	// DefaultCompactThreshold is default number of log records that triggers compaction
+	DefaultCompactThreshold = 1000
+)
+
+const (
+	logOperationSet    = "set"
+	logOperationDelete = "delete"
+)
+
+// logRecord is a single change stored in the log file
+type logRecord struct {
+	Operation string          `json:"op"`
+	Id        any             `json:"id"`
+	Item      json.RawMessage `json:"item,omitempty"`
+}
+
+// LogFilePersister is a log-structured persistence component that keeps data
+// in a snapshot file and an append-only log of changes.
+//
+// The snapshot is a JSON array of items stored in the configured path.
+// The log is stored next to it in "<path>.log" with one JSON record per line
+// describing a created or updated item, or an id of deleted item.
+// On Load the snapshot is read and the log is replayed over it.
+// On Save only changes since the previous Save are appended to the log.
+// As IChangeSaver it receives the changes from MemoryPersistence directly,
+// so the cost of a write doesn't depend on the number of stored items.
+// When the log grows over compaction threshold, the current state is written
+// into a new snapshot and the log is truncated.
+//
+// The data items must have "Id" property.
+//	Important: this component is thread save!
+//	Configuration parameters:
+//		- path to the snapshot file where data is stored
+//		- options:
+//			- compact_threshold: number of log records that triggers compaction (default: 1000)
+//			- file_mode: octal permissions of data files (default: "0644")
+//			- backups: number of previous copies of the snapshot (default: 0)
+//			- compress: true to compress the snapshot with gzip on save, compressed files are detected on load (default: false)
+//			- encryption_key: AES-256 key to encrypt the snapshot and log records, as base64 or hex string
+//			- allow_plaintext: true to load unencrypted snapshot and log records to encrypt existing data (default: false)
+//			- checksum: true to keep SHA-256 checksum of the snapshot file in "<path>.sha256" and verify it on load (default: false)
+//			- on_corruption: "fail" to return an error or "recover" to load the newest valid backup of the snapshot (default: "fail")
+//			- old_encryption_keys: comma-separated list of previous keys to read data after key rotation
+//		- credential:
+//			- store_key: (optional) a key to retrieve the encryption key from ICredentialStore
+//			- encryption_key: AES-256 key, an alternative to encryption_key option
+//	References:
+//		- *:logger:*:*:1.0 (optional) loggers to log warnings
+//		- *:credential_store:*:*:1.0 (optional) credential stores to resolve the encryption key
+//	Typed params:
+//		- T any type with "Id" property
+//	Example:
+//		persister := NewLogFilePersister[MyData]("./data/data.json")
+//		persistence := NewIdentifiableMemoryPersistence[MyData, string]()
+//		persistence.Loader = persister
+//		persistence.Saver = persister
+//	Implements: ILoader, ISaver, IChangeSaver, IConfigurable, IReferenceable
+type LogFilePersister[T any] struct {
+	file      dataFile
+	threshold int
+	convertor convert.IJSONEngine[T]
+
+	mtx     sync.Mutex
+	loaded  bool
+	ids     []string
+	entries map[string]logEntry
+	records int
+	// stale is true when data was encrypted with a previous key or not encrypted at all
+	stale bool
+}
+
+// logEntry is a serialized item known to the persister
+type logEntry struct {
+	id   any
+	data []byte
+}
+
+// NewLogFilePersister creates a new instance of the persister.
+//	Typed params:
+//		- T any type with "Id" property
+//	Parameters: path string (optional) a path to the snapshot file where data is stored.
+func NewLogFilePersister[T any](path string) *LogFilePersister[T] {
+	return &LogFilePersister[T]{
+		file:      newDataFile(path),
+		threshold: DefaultCompactThreshold,
+		convertor: convert.NewDefaultCustomTypeJsonConvertor[T](),
+		entries:   make(map[string]logEntry),
+	}
+}
+
+// Path gets the path of snapshot file.
+//	Returns: the file path where data is stored.
+func (c *LogFilePersister[T]) Path() string {
+	return c.file.path
+}
+
+// SetPath the path of snapshot file.
+//	Parameters:
+//		- value string the file path where data is stored.
+func (c *LogFilePersister[T]) SetPath(value string) {
+	c.file.path = value
+}
+
+// LogPath gets the path of log file.
+//	Returns: the file path where changes are appended.
+func (c *LogFilePersister[T]) LogPath() string {
+	return c.file.path + ".log"
+}
+
+// Configure component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config: ConfigParams configuration parameters to be set.
+func (c *LogFilePersister[T]) Configure(ctx context.Context, config *config.ConfigParams) {
+	c.file.configure(config)
+	c.threshold = config.GetAsIntegerWithDefault(ConfigParamCompactThreshold, c.threshold)
+}
+
+// SetReferences sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references refer.IReferences references to locate the component dependencies.
+func (c *LogFilePersister[T]) SetReferences(ctx context.Context, references refer.IReferences) {
+	c.file.setReferences(ctx, references)
+}
+
+// Load data items from snapshot and log files.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+// Returns: []T, error loaded items or error.
+func (c *LogFilePersister[T]) Load(ctx context.Context, correlationId string) ([]T, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if err := c.loadState(correlationId); err != nil {
+		return nil, err
+	}
+
+	if len(c.ids) == 0 {
+		return nil, nil
+	}
+
+	items := make([]T, 0, len(c.ids))
+	for _, key := range c.ids {
+		item, err := c.convertor.FromJson(string(c.entries[key].data))
+		if err != nil {
+			return nil, errors.NewFileError(
+				correlationId,
+				"READ_FAILED",
+				"Failed to parse item "+key+" in data file: "+c.file.path).
+				WithCause(err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Save given data items. Only changes since the previous Save are appended to the log.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+//		- items []T list of data items to save
+//  Returns: error or nil for success.
+func (c *LogFilePersister[T]) Save(ctx context.Context, correlationId string, items []T) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.loaded {
+		if err := c.loadState(correlationId); err != nil {
+			return err
+		}
+	}
+
+	records := make([]logRecord, 0)
+	ids := make([]string, 0, len(items))
+	entries := make(map[string]logEntry, len(items))
+
+	for _, item := range items {
+		entry, err := c.toEntry(correlationId, item)
+		if err != nil {
+			return err
+		}
+		key := idKey(entry.id)
+
+		if old, ok := c.entries[key]; !ok || !bytes.Equal(old.data, entry.data) {
+			records = append(records, logRecord{Operation: logOperationSet, Id: entry.id, Item: entry.data})
+		}
+		if _, ok := entries[key]; !ok {
+			ids = append(ids, key)
+		}
+		entries[key] = entry
+	}
+
+	for _, key := range c.ids {
+		if _, ok := entries[key]; !ok {
+			records = append(records, logRecord{Operation: logOperationDelete, Id: c.entries[key].id})
+		}
+	}
+
+	if err := c.appendRecords(correlationId, records); err != nil {
+		return err
+	}
+
+	c.ids = ids
+	c.entries = entries
+	return c.compactIfNeeded(correlationId)
+}
+
+// SaveChanges appends given changes to the log without comparing all items.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+//		- changes ChangeSet[T] inserted, updated and deleted items
+//  Returns: error or nil for success.
+func (c *LogFilePersister[T]) SaveChanges(ctx context.Context, correlationId string, changes ChangeSet[T]) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.loaded {
+		if err := c.loadState(correlationId); err != nil {
+			return err
+		}
+	}
+
+	records := make([]logRecord, 0, len(changes.Inserted)+len(changes.Updated)+len(changes.Deleted))
+	entries := make([]logEntry, 0, len(changes.Inserted)+len(changes.Updated))
+	for _, list := range [][]T{changes.Inserted, changes.Updated} {
+		for _, item := range list {
+			entry, err := c.toEntry(correlationId, item)
+			if err != nil {
+				return err
+			}
+			records = append(records, logRecord{Operation: logOperationSet, Id: entry.id, Item: entry.data})
+			entries = append(entries, entry)
+		}
+	}
+	for _, id := range changes.Deleted {
+		records = append(records, logRecord{Operation: logOperationDelete, Id: id})
+	}
+
+	if err := c.appendRecords(correlationId, records); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		c.setEntry(entry)
+	}
+	for _, record := range records[len(entries):] {
+		_ = c.applyRecord(correlationId, record)
+	}
+	return c.compactIfNeeded(correlationId)
+}
+
+// Compact writes the current state into the snapshot file and truncates the log.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+//  Returns: error or nil for success.
+func (c *LogFilePersister[T]) Compact(ctx context.Context, correlationId string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.loaded {
+		if err := c.loadState(correlationId); err != nil {
+			return err
+		}
+	}
+	return c.compact(correlationId)
+}
+
+func (c *LogFilePersister[T]) compactIfNeeded(correlationId string) error {
+	// Data encrypted with previous keys is re-encrypted by compaction
+	if c.stale || c.threshold > 0 && c.records >= c.threshold {
+		return c.compact(correlationId)
+	}
+	return nil
+}
+
+func (c *LogFilePersister[T]) compact(correlationId string) error {
+	err := c.file.writeWith(correlationId, func(w io.Writer) error {
+		if _, err := io.WriteString(w, "["); err != nil {
+			return err
+		}
+		for i, key := range c.ids {
+			if i > 0 {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			if _, err := w.Write(c.entries[key].data); err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, "]")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Replay of records is idempotent, so a crash before truncation
+	// leaves the data consistent
+	if err := os.Truncate(c.LogPath(), 0); err != nil && !os.IsNotExist(err) {
+		return c.logError(correlationId, "WRITE_FAILED", "Failed to truncate log file: ", err)
+	}
+	c.records = 0
+	c.stale = false
+	return nil
+}
+
+// loadState reads snapshot and replays log records
+func (c *LogFilePersister[T]) loadState(correlationId string) error {
+	c.ids = make([]string, 0)
+	c.entries = make(map[string]logEntry)
+	c.records = 0
+	c.stale = false
+
+	data, err := c.file.read(correlationId)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(bytes.TrimSpace(data)) > 0 {
+		var list []json.RawMessage
+		if err := json.Unmarshal(data, &list); err != nil {
+			return errors.NewFileError(
+				correlationId,
+				"READ_FAILED",
+				"Failed to parse data file: "+c.file.path).
+				WithCause(err)
+		}
+		for _, raw := range list {
+			item, err := c.convertor.FromJson(string(raw))
+			if err != nil {
+				return errors.NewFileError(
+					correlationId,
+					"READ_FAILED",
+					"Failed to parse data file: "+c.file.path).
+					WithCause(err)
+			}
+			entry, err := c.toEntry(correlationId, item)
+			if err != nil {
+				return err
+			}
+			c.setEntry(entry)
+		}
+	}
+
+	if err := c.replayLog(correlationId); err != nil {
+		return err
+	}
+	c.stale = c.stale || c.file.stale
+
+	c.loaded = true
+	return nil
+}
+
+// replayLog applies records from the log file.
+// A torn record at the end of the log (after a crash during append) is discarded.
+func (c *LogFilePersister[T]) replayLog(correlationId string) error {
+	file, err := os.Open(c.LogPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return c.logError(correlationId, "READ_FAILED", "Failed to read log file: ", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	line := 0
+	for {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return c.logError(correlationId, "READ_FAILED", "Failed to read log file: ", err)
+		}
+		complete := err == nil
+		if len(data) == 0 {
+			break
+		}
+		lineSize := len(data)
+		line++
+
+		var record logRecord
+		if complete {
+			sealed, ok := sealedLogRecord(data)
+			if c.file.encryption.enabled() && !c.file.encryption.isCurrent(sealed) {
+				c.stale = true
+			}
+			if ok {
+				// Decryption errors mean a wrong key, not a damaged record
+				if data, err = c.file.encryption.decrypt(correlationId, c.LogPath(), sealed); err != nil {
+					return err
+				}
+			} else if err := c.file.encryption.checkPlaintext(correlationId, c.LogPath(), bytes.TrimSpace(data)); err != nil {
+				return err
+			}
+		}
+		if !complete || decodeLogRecord(data, &record) != nil {
+			if _, err := reader.Peek(1); complete && err == nil {
+				return errors.NewFileError(
+					correlationId,
+					"CORRUPTED_LOG",
+					"Corrupted record at line "+strconv.Itoa(line)+" of log file: "+c.LogPath()).
+					WithDetails("line", line)
+			}
+			// Cut off the torn record, so next records are appended after the valid ones
+			if err := os.Truncate(c.LogPath(), offset); err != nil {
+				return c.logError(correlationId, "WRITE_FAILED", "Failed to truncate log file: ", err)
+			}
+			break
+		}
+		offset += int64(lineSize)
+
+		if err := c.applyRecord(correlationId, record); err != nil {
+			return err
+		}
+		c.records++
+	}
+	return nil
+}
+
+func (c *LogFilePersister[T]) applyRecord(correlationId string, record logRecord) error {
+	switch record.Operation {
+	case logOperationSet:
+		item, err := c.convertor.FromJson(string(record.Item))
+		if err != nil {
+			return c.logError(correlationId, "READ_FAILED", "Failed to parse item in log file: ", err)
+		}
+		entry, err := c.toEntry(correlationId, item)
+		if err != nil {
+			return err
+		}
+		c.setEntry(entry)
+	case logOperationDelete:
+		key := idKey(record.Id)
+		if _, ok := c.entries[key]; ok {
+			delete(c.entries, key)
+			for i, id := range c.ids {
+				if id == key {
+					c.ids = append(c.ids[:i], c.ids[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (c *LogFilePersister[T]) setEntry(entry logEntry) {
+	key := idKey(entry.id)
+	if _, ok := c.entries[key]; !ok {
+		c.ids = append(c.ids, key)
+	}
+	c.entries[key] = entry
+}
+
+func (c *LogFilePersister[T]) appendRecords(correlationId string, records []logRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	if err := c.file.checkPath(correlationId); err != nil {
+		return err
+	}
+	if err := c.file.encryption.check(correlationId); err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return errors.NewInternalError(correlationId, "CAN'T_CONVERT", "Failed convert to JSON").
+				WithCause(err)
+		}
+		if c.file.encryption.enabled() {
+			if data, err = c.file.encryption.encrypt(correlationId, data); err != nil {
+				return err
+			}
+			data = []byte(base64.StdEncoding.EncodeToString(data))
+		}
+		buffer.Write(data)
+		buffer.WriteByte('\n')
+	}
+
+	file, err := os.OpenFile(c.LogPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, c.file.mode)
+	if err != nil {
+		return c.logError(correlationId, "WRITE_FAILED", "Failed to open log file: ", err)
+	}
+	if _, err = file.Write(buffer.Bytes()); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return c.logError(correlationId, "WRITE_FAILED", "Failed to write log file: ", err)
+	}
+
+	c.records += len(records)
+	return nil
+}
+
+// toEntry serializes the item and extracts its id
+func (c *LogFilePersister[T]) toEntry(correlationId string, item T) (logEntry, error) {
+	id := GetObjectId(item)
+	if id == nil {
+		return logEntry{}, errors.NewBadRequestError(
+			correlationId,
+			"NO_ID",
+			"Data item has no Id property")
+	}
+
+	data, err := c.convertor.ToJson(item)
+	if err != nil {
+		return logEntry{}, errors.NewInternalError(correlationId, "CAN'T_CONVERT", "Failed convert to JSON").
+			WithCause(err)
+	}
+	return logEntry{id: id, data: []byte(data)}, nil
+}
+
+func (c *LogFilePersister[T]) logError(correlationId string, code string, message string, err error) error {
+	return errors.NewFileError(correlationId, code, message+c.LogPath()).WithCause(err)
+}
+
+// sealedLogRecord decodes encrypted record that is stored as base64 string
+func sealedLogRecord(data []byte) ([]byte, bool) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] == '{' {
+		return nil, false
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil || !isEncrypted(sealed) {
+		return nil, false
+	}
+	return sealed, true
+}
+
+// decodeLogRecord parses a record keeping numeric ids exact
+func decodeLogRecord(data []byte, record *logRecord) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(record)
+}
+
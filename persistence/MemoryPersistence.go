@@ -3,13 +3,16 @@ package persistence
 import (
 	"context"
 	"math/rand"
+	"os"
 	"sort"
 	"sync"
 	"time"
 
+	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
 	"github.com/pip-services3-gox/pip-services3-commons-gox/convert"
 
 	cdata "github.com/pip-services3-gox/pip-services3-commons-gox/data"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
 	"github.com/pip-services3-gox/pip-services3-commons-gox/refer"
 	"github.com/pip-services3-gox/pip-services3-components-gox/log"
 )
@@ -25,9 +28,48 @@ import (
 //	That allows to use it as a base struct for file and other types
 //	of persistence components that cache all data in memory.
 //
+//	The capacity of the persistence can be limited by a number of items
+//	or by an approximate size of items in bytes. When the capacity is exceeded
+//	items are evicted according to the chosen policy and OnEvict callback is called.
+//	Only items returned by reads count as used, listing of all items doesn't change their usage.
+//	The item stored by a write is never evicted by the same write, and an item
+//	that alone exceeds the maximum size is rejected with BadRequestError "ITEM_TOO_LARGE".
+//
+//	By default changes stay in memory when the saver fails to save them.
+//	When RollbackOnSaveError is enabled, items are saved under the write lock
+//	and a failed save reverts the in-memory change, so memory and the data source never diverge.
+//
+//	In write-behind mode changes are not saved by every write operation.
+//	Instead, the persistence is marked as dirty and a background goroutine started on Open
+//	saves items once per flush interval or when the number of pending changes reaches the limit.
+//	Pending changes are saved on Close or by explicit call to Flush method.
+//	Rollback of failed saves is not supported in this mode, use LastSaveError to check the result of saves.
+//
+//	When the saver implements IChangeSaver interface, write operations pass only
+//	inserted, updated and deleted items to it instead of saving all items with ISaver.
+//	Changes of items without ids are always saved with ISaver.
+//
+//	When WatchInterval is set and the loader reads a file or a directory (has Path method),
+//	the data file is polled for external changes. A changed file is reloaded through
+//	the loader under the write lock and reload listeners are notified.
+//	Own saves don't trigger reloads. The file is not reloaded while own changes are pending,
+//	they overwrite external changes on save. Items are kept when the data file is removed.
+//
+//	Export and Import methods copy items to and from JSON, NDJSON or CSV streams,
+//	for instance to backup the persistence independently of its data source.
+//
 //	Important:
 //		- this component is a thread save!
 //		- if data object will implement ICloneable interface, it rises speed of execution
+//	Configuration parameters:
+//		- options:
+//			- max_items: maximum number of stored items, 0 for unlimited (default: 0)
+//			- max_bytes: approximate maximum size of stored items in bytes, 0 for unlimited (default: 0)
+//			- eviction_policy: policy to evict items: "lru", "lfu" or "fifo" (default: "lru")
+//			- rollback_on_save_error: true to revert in-memory changes when saving fails (default: false)
+//			- flush_interval: interval in milliseconds to save changes in write-behind mode, 0 to disable (default: 0)
+//			- max_dirty: number of pending changes that triggers saving in write-behind mode, 0 to disable (default: 0)
+//			- watch_interval: interval in milliseconds to check the data file for external changes, 0 to disable (default: 0)
 //	References:
 //		*:logger:*:*:1.0    ILogger components to pass log messages
 //	Typed params:
@@ -49,7 +91,7 @@ import (
 //			return defaultValue, nil
 //		}
 //
-//	Implements: IReferenceable, IOpenable, ICleanable
+//	Implements: IConfigurable, IReferenceable, IOpenable, ICleanable
 type MemoryPersistence[T any] struct {
 	Logger      *log.CompositeLogger
 	Items       []T
@@ -59,8 +101,43 @@ type MemoryPersistence[T any] struct {
 	opened      bool
 	MaxPageSize int
 	convertor   convert.IJSONEngine[T]
+
+	// MaxItems is maximum number of stored items, 0 for unlimited
+	MaxItems int
+	// MaxBytes is approximate maximum size of stored items in bytes, 0 for unlimited
+	MaxBytes int64
+	// EvictionPolicy defines which items are evicted first when capacity is exceeded
+	EvictionPolicy EvictionPolicy
+	// OnEvict is called for every evicted item
+	OnEvict func(ctx context.Context, correlationId string, item T)
+	tracker evictionTracker
+
+	// RollbackOnSaveError enables reverting of in-memory changes when saving fails
+	RollbackOnSaveError bool
+
+	// FlushInterval is interval to save changes in write-behind mode, 0 to disable
+	FlushInterval time.Duration
+	// MaxDirtyOperations is number of pending changes that triggers saving in write-behind mode, 0 to disable
+	MaxDirtyOperations int
+	writeBehind        writeBehind
+
+	// WatchInterval is interval to check the data file for external changes, 0 to disable
+	WatchInterval time.Duration
+	watcher       reloadWatcher
+
+	changes *changeTracker[T]
 }
 
+const (
+	MemoryPersistenceConfigParamOptionsMaxItems       = "options.max_items"
+	MemoryPersistenceConfigParamOptionsMaxBytes       = "options.max_bytes"
+	MemoryPersistenceConfigParamOptionsEvictionPolicy = "options.eviction_policy"
+	MemoryPersistenceConfigParamOptionsRollback       = "options.rollback_on_save_error"
+	MemoryPersistenceConfigParamOptionsFlushInterval  = "options.flush_interval"
+	MemoryPersistenceConfigParamOptionsMaxDirty       = "options.max_dirty"
+	MemoryPersistenceConfigParamOptionsWatchInterval  = "options.watch_interval"
+)
+
 // NewMemoryPersistence creates a new instance of the MemoryPersistence
 //	Typed params:
 //		- T cdata.ICloneable[T] any type that implemented
@@ -68,13 +145,35 @@ type MemoryPersistence[T any] struct {
 //	Return *MemoryPersistence[T]
 func NewMemoryPersistence[T any]() *MemoryPersistence[T] {
 	c := &MemoryPersistence[T]{
-		convertor: convert.NewDefaultCustomTypeJsonConvertor[T](),
+		convertor:      convert.NewDefaultCustomTypeJsonConvertor[T](),
+		EvictionPolicy: EvictionPolicyLru,
 	}
+	c.changes = newChangeTracker[T](func(item T) any {
+		return GetObjectId(item)
+	})
 	c.Logger = log.NewCompositeLogger()
 	c.Items = make([]T, 0, 10)
 	return c
 }
 
+// Configure component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config *config.ConfigParams configuration parameters to be set.
+func (c *MemoryPersistence[T]) Configure(ctx context.Context, config *config.ConfigParams) {
+	c.MaxItems = config.GetAsIntegerWithDefault(MemoryPersistenceConfigParamOptionsMaxItems, c.MaxItems)
+	c.MaxBytes = config.GetAsLongWithDefault(MemoryPersistenceConfigParamOptionsMaxBytes, c.MaxBytes)
+	if policy, ok := config.GetAsNullableString(MemoryPersistenceConfigParamOptionsEvictionPolicy); ok {
+		c.EvictionPolicy = ParseEvictionPolicy(policy)
+	}
+	c.RollbackOnSaveError = config.GetAsBooleanWithDefault(MemoryPersistenceConfigParamOptionsRollback, c.RollbackOnSaveError)
+	c.FlushInterval = time.Duration(config.GetAsLongWithDefault(MemoryPersistenceConfigParamOptionsFlushInterval,
+		c.FlushInterval.Milliseconds())) * time.Millisecond
+	c.MaxDirtyOperations = config.GetAsIntegerWithDefault(MemoryPersistenceConfigParamOptionsMaxDirty, c.MaxDirtyOperations)
+	c.WatchInterval = time.Duration(config.GetAsLongWithDefault(MemoryPersistenceConfigParamOptionsWatchInterval,
+		c.WatchInterval.Milliseconds())) * time.Millisecond
+}
+
 // SetReferences references to dependent components.
 //	Parameters:
 //		- ctx context.Context
@@ -91,7 +190,8 @@ func (c *MemoryPersistence[T]) IsOpen() bool {
 	return c.opened
 }
 
-// Open the component.
+// Open the component and load items using configured loader component.
+// Load errors, except a missing data file, are returned and the component stays closed.
 //	Parameters:
 //		- ctx context.Context	operation context
 //		- correlationId  string (optional) transaction id to trace execution through call chain.
@@ -101,10 +201,18 @@ func (c *MemoryPersistence[T]) Open(ctx context.Context, correlationId string) e
 	defer c.Mtx.Unlock()
 
 	if c.Loader == nil {
+		c.startWriteBehind()
 		return nil
 	}
 
 	items, err := c.Loader.Load(ctx, correlationId)
+	if err != nil && !os.IsNotExist(err) {
+		// Data that can't be read, for instance because of a wrong encryption key,
+		// shall not be silently replaced with empty data on the next save
+		c.Logger.Error(ctx, correlationId, err, "Failed to load items")
+		return err
+	}
+	c.startWriteBehind()
 	if err == nil && items != nil {
 		c.Items = make([]T, len(items))
 		for i, v := range items {
@@ -113,17 +221,26 @@ func (c *MemoryPersistence[T]) Open(ctx context.Context, correlationId string) e
 		length := len(c.Items)
 		c.Logger.Trace(ctx, correlationId, "Loaded %d items", length)
 	}
+	c.tracker.reset(len(c.Items))
+	c.changes.reset()
+	evicted := c.evictItems(-1)
 	c.opened = true
+	c.startWatcher()
+
+	c.notifyEvicted(ctx, correlationId, evicted)
 	return nil
 }
 
 // Close component and frees used resources.
+// In write-behind mode it stops background saving and flushes pending changes.
 //	Parameters:
 //		- ctx context.Context	operation context
 //		- correlationId  string (optional) transaction id to trace execution through call chain.
 //	Returns: error or null no errors occurred.
 func (c *MemoryPersistence[T]) Close(ctx context.Context, correlationId string) error {
-	err := c.Save(ctx, correlationId)
+	c.stopWatcher()
+	c.stopWriteBehind()
+	err := c.flush(ctx, correlationId, true)
 	c.Mtx.Lock()
 	defer c.Mtx.Unlock()
 	c.opened = false
@@ -139,18 +256,83 @@ func (c *MemoryPersistence[T]) Save(ctx context.Context, correlationId string) e
 	c.Mtx.RLock()
 	defer c.Mtx.RUnlock()
 
+	return c.saveItems(ctx, correlationId)
+}
+
+// saveItems passes items to the saver. Must be called under lock.
+func (c *MemoryPersistence[T]) saveItems(ctx context.Context, correlationId string) error {
 	if c.Saver == nil {
 		return nil
 	}
 
 	err := c.Saver.Save(ctx, correlationId, c.Items)
 	if err == nil {
+		// All collected changes are saved as well
+		c.changes.reset()
+		c.refreshWatcher()
+
 		length := len(c.Items)
 		c.Logger.Trace(ctx, correlationId, "Saved %d items", length)
 	}
 	return err
 }
 
+// saveChanges saves collected changes when the saver implements IChangeSaver
+// or all items otherwise. Changes that failed to save are kept to retry them later.
+func (c *MemoryPersistence[T]) saveChanges(ctx context.Context, correlationId string) error {
+	changeSaver, ok := c.Saver.(IChangeSaver[T])
+	if !ok {
+		return c.Save(ctx, correlationId)
+	}
+
+	changes, full := c.changes.take()
+	if full {
+		err := c.Save(ctx, correlationId)
+		if err != nil {
+			c.changes.restore(changes, full)
+		}
+		return err
+	}
+
+	if changes.IsEmpty() {
+		return nil
+	}
+
+	if err := changeSaver.SaveChanges(ctx, correlationId, changes); err != nil {
+		c.changes.restore(changes, full)
+		return err
+	}
+	c.refreshWatcher()
+
+	c.Logger.Trace(ctx, correlationId, "Saved %d changes",
+		len(changes.Inserted)+len(changes.Updated)+len(changes.Deleted))
+	return nil
+}
+
+// saveChangesLocked saves collected changes or all items without taking locks.
+// Must be called under write lock. Changes that failed to save are discarded.
+func (c *MemoryPersistence[T]) saveChangesLocked(ctx context.Context, correlationId string) error {
+	changeSaver, ok := c.Saver.(IChangeSaver[T])
+	if !ok {
+		return c.saveItems(ctx, correlationId)
+	}
+
+	changes, full := c.changes.take()
+	if full {
+		return c.saveItems(ctx, correlationId)
+	}
+
+	if changes.IsEmpty() {
+		return nil
+	}
+
+	if err := changeSaver.SaveChanges(ctx, correlationId, changes); err != nil {
+		return err
+	}
+	c.refreshWatcher()
+	return nil
+}
+
 // Clear component state.
 //	Parameters:
 //		- ctx context.Context	operation context
@@ -164,6 +346,8 @@ func (c *MemoryPersistence[T]) Clear(ctx context.Context, correlationId string)
 	defer c.Mtx.Unlock()
 
 	c.Items = make([]T, 0, 5)
+	c.tracker.reset(0)
+	c.changes.reset()
 	c.Logger.Trace(ctx, correlationId, "Cleared items")
 
 	return nil
@@ -192,43 +376,31 @@ func (c *MemoryPersistence[T]) GetPageByFilter(ctx context.Context, correlationI
 	c.Mtx.RLock()
 	defer c.Mtx.RUnlock()
 
-	items := make([]T, 0, len(c.Items))
-
-	// Apply filtering
-	if filterFunc != nil {
-		for _, v := range c.Items {
-			if filterFunc(v) {
-				items = append(items, c.cloneItem(v))
-			}
-		}
-	} else {
-		for _, v := range c.Items {
-			items = append(items, c.cloneItem(v))
-		}
-	}
-
-	// Apply sorting
-	if sortFunc != nil {
-		localSort := sorter[T]{items: items, compFunc: sortFunc}
-		sort.Sort(localSort)
-	}
+	entries := c.filterItems(filterFunc, sortFunc)
 
 	// Extract a page
 	skip := paging.GetSkip(-1)
 	take := paging.GetTake((int64)(c.MaxPageSize))
 	var total int64
 	if paging.Total {
-		total = (int64)(len(items))
+		total = (int64)(len(entries))
 	}
 	if skip > 0 {
-		_len := (int64)(len(items))
+		_len := (int64)(len(entries))
 		if skip >= _len {
 			skip = _len
 		}
-		items = items[skip:]
+		entries = entries[skip:]
 	}
-	if (int64)(len(items)) >= take {
-		items = items[:take]
+	if (int64)(len(entries)) >= take {
+		entries = entries[:take]
+	}
+
+	// Only returned items are counted as used
+	items := make([]T, len(entries))
+	for i, entry := range entries {
+		items[i] = entry.item
+		c.touchItem(entry.index)
 	}
 
 	// Get projection
@@ -263,30 +435,18 @@ func (c *MemoryPersistence[T]) GetListByFilter(ctx context.Context, correlationI
 	c.Mtx.RLock()
 	defer c.Mtx.RUnlock()
 
-	// Apply filter
-	items := make([]T, 0, len(c.Items))
-
-	// Apply filtering
-	if filterFunc != nil {
-		for _, v := range c.Items {
-			if filterFunc(v) {
-				items = append(items, c.cloneItem(v))
-			}
-		}
-	} else {
-		for _, v := range c.Items {
-			items = append(items, c.cloneItem(v))
-		}
-	}
-
-	if len(items) == 0 {
+	entries := c.filterItems(filterFunc, sortFunc)
+	if len(entries) == 0 {
 		return nil, nil
 	}
 
-	// Apply sorting
-	if sortFunc != nil {
-		localSort := sorter[T]{items: items, compFunc: sortFunc}
-		sort.Sort(localSort)
+	items := make([]T, len(entries))
+	for i, entry := range entries {
+		items[i] = entry.item
+		// Listing of all items is a scan rather than usage of particular items
+		if filterFunc != nil {
+			c.touchItem(entry.index)
+		}
 	}
 
 	// Get projection
@@ -301,6 +461,32 @@ func (c *MemoryPersistence[T]) GetListByFilter(ctx context.Context, correlationI
 	return items, nil
 }
 
+// indexedItem is a copy of stored item with its index in Items
+type indexedItem[T any] struct {
+	index int
+	item  T
+}
+
+// filterItems copies items that match a given filter and sorts them.
+// Must be called under lock.
+//	Returns: []indexedItem[T] copies of matched items with their indexes
+func (c *MemoryPersistence[T]) filterItems(filterFunc func(T) bool, sortFunc func(T, T) bool) []indexedItem[T] {
+	entries := make([]indexedItem[T], 0, len(c.Items))
+	for i, v := range c.Items {
+		if filterFunc == nil || filterFunc(v) {
+			entries = append(entries, indexedItem[T]{index: i, item: c.cloneItem(v)})
+		}
+	}
+
+	if sortFunc != nil {
+		localSort := sorter[indexedItem[T]]{items: entries, compFunc: func(a, b indexedItem[T]) bool {
+			return sortFunc(a.item, b.item)
+		}}
+		sort.Sort(localSort)
+	}
+	return entries
+}
+
 // GetOneRandom gets a random item from items that match to a given filter.
 // This method shall be called by a func (c* IdentifiableMemoryPersistence) GetOneRandom method from child type that
 // receives FilterParams and converts them into a filter function.
@@ -317,24 +503,29 @@ func (c *MemoryPersistence[T]) GetOneRandom(ctx context.Context, correlationId s
 
 	// Apply filter
 	items := make([]T, 0, len(c.Items))
+	indexes := make([]int, 0, len(c.Items))
 
 	// Apply filtering
 	if filterFunc != nil {
-		for _, v := range c.Items {
+		for i, v := range c.Items {
 			if filterFunc(v) {
 				items = append(items, c.cloneItem(v))
+				indexes = append(indexes, i)
 			}
 		}
 	} else {
-		for _, v := range c.Items {
+		for i, v := range c.Items {
 			items = append(items, c.cloneItem(v))
+			indexes = append(indexes, i)
 		}
 	}
 	rand.Seed(time.Now().UnixNano())
 
 	var item *T = nil
 	if len(items) > 0 {
-		item = &items[rand.Intn(len(items))]
+		index := rand.Intn(len(items))
+		item = &items[index]
+		c.touchItem(indexes[index])
 	}
 
 	if item != nil {
@@ -356,20 +547,27 @@ func (c *MemoryPersistence[T]) GetOneRandom(ctx context.Context, correlationId s
 //		- item T an item to be created.
 //	Returns: T, error created item or error.
 func (c *MemoryPersistence[T]) Create(ctx context.Context, correlationId string, item T) (T, error) {
+	newItem := c.cloneItem(item)
+	if err := c.checkItemSize(correlationId, newItem); err != nil {
+		var defaultValue T
+		return defaultValue, err
+	}
 
 	c.Mtx.Lock()
+	snapshot := c.takeSnapshot()
 
-	c.Items = append(c.Items, c.cloneItem(item))
+	c.Items = append(c.Items, newItem)
+	c.tracker.insert()
+	c.recordInsert(newItem)
+	evicted := c.evictItems(len(c.Items) - 1)
 
 	c.Logger.Trace(ctx, correlationId, "Created item")
 
-	c.Mtx.Unlock()
-
-	if err := c.Save(ctx, correlationId); err != nil {
-		return c.cloneItem(item), err
+	if err := c.completeWrite(ctx, correlationId, &c.Mtx, snapshot, evicted); err != nil {
+		return c.cloneItem(newItem), err
 	}
 
-	return c.cloneItem(item), nil
+	return c.cloneItem(newItem), nil
 }
 
 // DeleteByFilter data items that match to a given filter.
@@ -385,29 +583,27 @@ func (c *MemoryPersistence[T]) DeleteByFilter(ctx context.Context, correlationId
 	filterFunc func(T) bool) error {
 
 	c.Mtx.Lock()
+	snapshot := c.takeSnapshot()
 
 	deleted := 0
 	for i := 0; i < len(c.Items); {
 		if filterFunc(c.Items[i]) {
-			if i == len(c.Items)-1 {
-				c.Items = c.Items[:i]
-			} else {
-				c.Items = append(c.Items[:i], c.Items[i+1:]...)
-			}
+			c.recordDelete(c.Items[i])
+			c.removeItemAt(i)
 			deleted++
 		} else {
 			i++
 		}
 	}
-	c.Mtx.Unlock()
 
 	if deleted == 0 {
+		c.Mtx.Unlock()
 		return nil
 	}
 
-	c.Logger.Trace(ctx, correlationId, "Deleted %s items", deleted)
+	c.Logger.Trace(ctx, correlationId, "Deleted %d items", deleted)
 
-	return c.Save(ctx, correlationId)
+	return c.completeWrite(ctx, correlationId, &c.Mtx, snapshot, nil)
 }
 
 // GetCountByFilter gets a count of data items retrieved by a given filter.
@@ -448,3 +644,204 @@ func (c *MemoryPersistence[T]) cloneItem(item any) T {
 	newItem, _ := c.convertor.FromJson(strObject)
 	return newItem
 }
+
+// recordInsert collects a created item when the saver accepts incremental changes.
+// Must be called under write lock.
+func (c *MemoryPersistence[T]) recordInsert(item T) {
+	if _, ok := c.Saver.(IChangeSaver[T]); ok {
+		c.changes.insert(c.cloneItem(item))
+	}
+}
+
+// recordUpdate collects an updated item when the saver accepts incremental changes.
+// Must be called under write lock.
+func (c *MemoryPersistence[T]) recordUpdate(item T) {
+	if _, ok := c.Saver.(IChangeSaver[T]); ok {
+		c.changes.update(c.cloneItem(item))
+	}
+}
+
+// recordDelete collects a deleted item when the saver accepts incremental changes.
+// Must be called under write lock.
+func (c *MemoryPersistence[T]) recordDelete(item T) {
+	if _, ok := c.Saver.(IChangeSaver[T]); ok {
+		c.changes.delete(item)
+	}
+}
+
+// touchItem records an access to the item with a given index.
+// It does nothing when the persistence capacity is not limited.
+func (c *MemoryPersistence[T]) touchItem(index int) {
+	if c.isBounded() {
+		c.tracker.touch(index)
+	}
+}
+
+// removeItemAt removes the item with a given index from Items
+// and its usage statistics. Must be called under write lock.
+func (c *MemoryPersistence[T]) removeItemAt(index int) {
+	if index == len(c.Items)-1 {
+		c.Items = c.Items[:index]
+	} else {
+		c.Items = append(c.Items[:index], c.Items[index+1:]...)
+	}
+	c.tracker.remove(index)
+}
+
+func (c *MemoryPersistence[T]) isBounded() bool {
+	return c.MaxItems > 0 || c.MaxBytes > 0
+}
+
+// checkItemSize checks that the item alone doesn't exceed MaxBytes,
+// otherwise it would be evicted right after it is stored.
+//	Returns: BadRequestError when the item is too large or nil.
+func (c *MemoryPersistence[T]) checkItemSize(correlationId string, item T) error {
+	if c.MaxBytes <= 0 {
+		return nil
+	}
+
+	size := c.sizeOfItem(item)
+	if size <= c.MaxBytes {
+		return nil
+	}
+	return errors.NewBadRequestError(
+		correlationId,
+		"ITEM_TOO_LARGE",
+		"Item size exceeds maximum size of the persistence").
+		WithDetails("size", size).
+		WithDetails("max_bytes", c.MaxBytes)
+}
+
+func (c *MemoryPersistence[T]) sizeOfItem(item T) int64 {
+	json, err := c.convertor.ToJson(item)
+	if err != nil {
+		return 0
+	}
+	return int64(len(json))
+}
+
+// evictItems removes items that exceed configured capacity according to eviction policy.
+// Must be called under write lock.
+//	Parameters:
+//		- keep int index of the item that was just written and must stay, -1 for none
+//	Returns: []T evicted items
+func (c *MemoryPersistence[T]) evictItems(keep int) []T {
+	if !c.isBounded() {
+		return nil
+	}
+
+	c.tracker.sync(len(c.Items))
+
+	var size int64
+	if c.MaxBytes > 0 {
+		size = c.tracker.totalSize(func(index int) int64 {
+			return c.sizeOfItem(c.Items[index])
+		})
+	}
+
+	var evicted []T
+	for len(c.Items) > 0 &&
+		(c.MaxItems > 0 && len(c.Items) > c.MaxItems || c.MaxBytes > 0 && size > c.MaxBytes) {
+
+		index, itemSize := c.tracker.victim(c.EvictionPolicy, keep)
+		if index < 0 {
+			break
+		}
+		evicted = append(evicted, c.Items[index])
+		c.recordDelete(c.Items[index])
+		c.removeItemAt(index)
+		size -= itemSize
+		if index < keep {
+			keep--
+		}
+	}
+	return evicted
+}
+
+// notifyEvicted logs evicted items and passes them to OnEvict callback.
+// Shall be called outside of locks to let the callback access the persistence.
+func (c *MemoryPersistence[T]) notifyEvicted(ctx context.Context, correlationId string, evicted []T) {
+	if len(evicted) == 0 {
+		return
+	}
+
+	c.Logger.Trace(ctx, correlationId, "Evicted %d items", len(evicted))
+
+	if c.OnEvict != nil {
+		for _, item := range evicted {
+			c.OnEvict(ctx, correlationId, item)
+		}
+	}
+}
+
+// itemsSnapshot keeps a state of items to restore it when changes can't be saved
+type itemsSnapshot[T any] struct {
+	items []T
+	stats []itemStats
+	// changes are pending changes of previous writes
+	changes     ChangeSet[T]
+	fullChanges bool
+}
+
+// takeSnapshot captures current items when RollbackOnSaveError is enabled.
+// Must be called under write lock before items are changed.
+//	Returns: *itemsSnapshot[T] captured state or nil when rollback is disabled.
+func (c *MemoryPersistence[T]) takeSnapshot() *itemsSnapshot[T] {
+	if !c.RollbackOnSaveError || c.Saver == nil || c.isWriteBehind() {
+		return nil
+	}
+
+	items := make([]T, len(c.Items))
+	copy(items, c.Items)
+	changes, full := c.changes.peek()
+	return &itemsSnapshot[T]{
+		items:       items,
+		stats:       c.tracker.snapshot(),
+		changes:     changes,
+		fullChanges: full,
+	}
+}
+
+// completeWrite finishes a write operation started under the write lock on mtx.
+// Without snapshot it releases the lock and saves items as usual or requests saving in write-behind mode.
+// With snapshot it saves items under the lock and restores the snapshot when saving fails.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string (optional) transaction id to trace execution through call chain.
+//		- mtx *sync.RWMutex the locked mutex that protects items
+//		- snapshot *itemsSnapshot[T] the state before the change or nil
+//		- evicted []T items evicted by the change
+//	Returns: error or nil for success.
+func (c *MemoryPersistence[T]) completeWrite(ctx context.Context, correlationId string,
+	mtx *sync.RWMutex, snapshot *itemsSnapshot[T], evicted []T) error {
+
+	if snapshot == nil {
+		// Reload must not replace the change before it is saved
+		c.beginSave()
+		mtx.Unlock()
+		c.notifyEvicted(ctx, correlationId, evicted)
+		err := c.requestSave(ctx, correlationId)
+		c.endSave(err)
+		return err
+	}
+
+	if err := c.saveChangesLocked(ctx, correlationId); err != nil {
+		c.Items = snapshot.items
+		c.tracker.restore(snapshot.stats)
+		// Only changes of the failed write are dropped, previous ones are still not saved
+		c.changes.reset()
+		c.changes.restore(snapshot.changes, snapshot.fullChanges)
+		mtx.Unlock()
+
+		c.Logger.Trace(ctx, correlationId, "Reverted changes that failed to save")
+		return errors.NewInternalError(
+			correlationId,
+			"CHANGES_NOT_APPLIED",
+			"Failed to save changes, nothing was applied").
+			WithCause(err)
+	}
+
+	mtx.Unlock()
+	c.notifyEvicted(ctx, correlationId, evicted)
+	return nil
+}
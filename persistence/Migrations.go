@@ -0,0 +1,133 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+)
+
+// MigrationFunc upgrades raw data items loaded from a data file by one version.
+// Items are passed as maps decoded from JSON, so renamed or removed fields
+// of previous versions are still available.
+//	Parameters:
+//		- items []map[string]any data items of the previous version
+//	Returns: []map[string]any, error data items of the next version or error.
+type MigrationFunc func(items []map[string]any) ([]map[string]any, error)
+
+// versionedEnvelope is a content of data file that keeps the version of its items
+type versionedEnvelope struct {
+	Version int             `json:"version"`
+	Items   json.RawMessage `json:"items"`
+}
+
+// dataMigrations keeps a chain of migrations of data files.
+// Data files of version 1 and higher are saved in versioned envelope
+// {"version": n, "items": [...]}, a plain list of items is version 0.
+// On load items of previous versions are passed through all migrations
+// up to the latest version one by one.
+// Only JsonFilePersister and YamlFilePersister keep the envelope. NDJSON, CSV, gob,
+// log and directory persisters store items one by one and don't support migrations.
+type dataMigrations struct {
+	migrations map[int]MigrationFunc
+	version    int
+}
+
+// add registers migration that upgrades items to a given version from the previous one
+func (c *dataMigrations) add(version int, migration MigrationFunc) {
+	if c.migrations == nil {
+		c.migrations = make(map[int]MigrationFunc)
+	}
+	c.migrations[version] = migration
+	if version > c.version {
+		c.version = version
+	}
+}
+
+// wrap puts items into versioned envelope when migrations are registered
+func (c *dataMigrations) wrap(items string) string {
+	if c.version == 0 {
+		return items
+	}
+	return "{\"version\":" + strconv.Itoa(c.version) + ",\"items\":" + items + "}"
+}
+
+// unwrap extracts items from data file content and upgrades them to the latest version.
+//	Returns: JSON of items, true when items were migrated, or error.
+func (c *dataMigrations) unwrap(correlationId string, path string, data []byte) ([]byte, bool, error) {
+	data = bytes.TrimSpace(data)
+	if !bytes.HasPrefix(data, []byte("{")) {
+		if c.version == 0 {
+			return data, false, nil
+		}
+		return c.migrate(correlationId, path, 0, data)
+	}
+
+	var envelope versionedEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, false, errors.NewFileError(
+			correlationId,
+			"READ_FAILED",
+			"Failed to parse data file: "+path).
+			WithCause(err)
+	}
+	if envelope.Version > c.version {
+		return nil, false, errors.NewFileError(
+			correlationId,
+			"UNSUPPORTED_VERSION",
+			"Data file version "+strconv.Itoa(envelope.Version)+" is newer than supported: "+path).
+			WithDetails("version", envelope.Version).
+			WithDetails("path", path)
+	}
+	if envelope.Version == c.version {
+		return envelope.Items, false, nil
+	}
+	return c.migrate(correlationId, path, envelope.Version, envelope.Items)
+}
+
+// migrate passes items of a given version through the chain of migrations
+func (c *dataMigrations) migrate(correlationId string, path string, version int, data []byte) ([]byte, bool, error) {
+	var items []map[string]any
+	if len(data) > 0 && !bytes.Equal(data, []byte("null")) {
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, false, errors.NewFileError(
+				correlationId,
+				"READ_FAILED",
+				"Failed to parse data file: "+path).
+				WithCause(err)
+		}
+	}
+
+	for next := version + 1; next <= c.version; next++ {
+		migration, ok := c.migrations[next]
+		if !ok {
+			return nil, false, errors.NewConfigError(
+				correlationId,
+				"MIGRATION_NOT_FOUND",
+				"Migration to version "+strconv.Itoa(next)+" is not registered").
+				WithDetails("version", next)
+		}
+
+		var err error
+		if items, err = migration(items); err != nil {
+			return nil, false, errors.NewFileError(
+				correlationId,
+				"MIGRATION_FAILED",
+				"Failed to migrate data file to version "+strconv.Itoa(next)+": "+path).
+				WithDetails("version", next).
+				WithDetails("path", path).
+				WithCause(err)
+		}
+	}
+
+	if items == nil {
+		items = []map[string]any{}
+	}
+	result, err := json.Marshal(items)
+	if err != nil {
+		return nil, false, errors.NewInternalError(correlationId, "CAN'T_CONVERT", "Failed convert to JSON").
+			WithCause(err)
+	}
+	return result, true, nil
+}
@@ -0,0 +1,240 @@
+package persistence
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/convert"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+)
+
+// ConfigParamSkipCorrupt defines if corrupt lines of NDJSON file are skipped on load
+const ConfigParamSkipCorrupt = "options.skip_corrupt"
+
+// NdjsonFilePersister is a persistence component that loads and saves data from/to
+// JSON Lines (NDJSON) file with one JSON item per line.
+// It is used by FilePersistence and IdentifiableFilePersistence the same way as JsonFilePersister.
+//
+// Items are read and written one by one, so LoadEach can process datasets
+// that don't fit into memory and Append adds items without rewriting the file.
+// Corrupt lines fail loading by default. With skip_corrupt option they are skipped,
+// logged as warnings and reported through CorruptLines and OnCorruptLine callback,
+// so a single damaged line doesn't make the whole file unreadable.
+//	Important: this component is not thread save!
+//	Configuration parameters:
+//		- path to the file where data is stored
+//		- options:
+//			- skip_corrupt: true to skip corrupt lines or false to fail loading (default: false)
+//			- file_mode: octal permissions of the data file (default: "0644")
+//			- backups: number of previous copies kept as "<path>.1", "<path>.2", ... (default: 0)
+//			- compress: true to compress the data file with gzip on save, compressed files are detected on load (default: false)
+//			- encryption_key: AES-256 key to encrypt the data file, see JsonFilePersister
+//			- checksum: true to keep SHA-256 checksum of the data file in "<path>.sha256" and verify it on load (default: false)
+//			- on_corruption: "fail" to return an error or "recover" to load the newest valid backup when the checksum doesn't match (default: "fail")
+//	Typed params:
+//		- T any type
+//	Example:
+//		persister := NewNdjsonFilePersister[MyData]("./data/data.ndjson")
+//		persister.Configure(context.Background(), config.NewConfigParamsFromTuples(
+//			"options.skip_corrupt", true,
+//		))
+//		persister.OnCorruptLine = func(line int, err error) {
+//			fmt.Println("Skipped line", line, err)
+//		}
+//		err := persister.LoadEach(context.Background(), "123", func(item MyData) error {
+//			fmt.Println(item)
+//			return nil
+//		})
+//	Implements: ILoader, ISaver, IConfigurable, IReferenceable
+type NdjsonFilePersister[T any] struct {
+	file        dataFile
+	skipCorrupt bool
+	convertor   convert.IJSONEngine[T]
+	corrupt     []int
+
+	// OnCorruptLine is called for every skipped corrupt line with its number starting from 1
+	OnCorruptLine func(line int, err error)
+}
+
+// NewNdjsonFilePersister creates a new instance of the persistence.
+//	Typed params:
+//		- T any type
+//	Parameters: path string (optional) a path to the file where data is stored.
+func NewNdjsonFilePersister[T any](path string) *NdjsonFilePersister[T] {
+	return &NdjsonFilePersister[T]{
+		file:        newDataFile(path),
+		skipCorrupt: false,
+		convertor:   convert.NewDefaultCustomTypeJsonConvertor[T](),
+	}
+}
+
+// Path gets the file path where data is stored.
+//	Returns: the file path where data is stored.
+func (c *NdjsonFilePersister[T]) Path() string {
+	return c.file.path
+}
+
+// SetPath the file path where data is stored.
+//	Parameters:
+//		- value string the file path where data is stored.
+func (c *NdjsonFilePersister[T]) SetPath(value string) {
+	c.file.path = value
+}
+
+// Configure component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config: ConfigParams configuration parameters to be set.
+func (c *NdjsonFilePersister[T]) Configure(ctx context.Context, config *config.ConfigParams) {
+	c.file.configure(config)
+	c.skipCorrupt = config.GetAsBooleanWithDefault(ConfigParamSkipCorrupt, c.skipCorrupt)
+}
+
+// SetReferences sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references refer.IReferences references to locate the component dependencies.
+func (c *NdjsonFilePersister[T]) SetReferences(ctx context.Context, references refer.IReferences) {
+	c.file.setReferences(ctx, references)
+}
+
+// CorruptLines gets numbers of corrupt lines skipped during the last load.
+//	Returns: line numbers starting from 1.
+func (c *NdjsonFilePersister[T]) CorruptLines() []int {
+	return c.corrupt
+}
+
+// Load data items from external NDJSON file.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+// Returns: []T, error loaded items or error.
+func (c *NdjsonFilePersister[T]) Load(ctx context.Context, correlationId string) ([]T, error) {
+	var items []T
+	err := c.LoadEach(ctx, correlationId, func(item T) error {
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// LoadEach reads data items from external NDJSON file one by one
+// and passes them to a callback function without keeping them in memory.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+//		- callback func(item T) error a function called for every item,
+//			an error returned by the function stops loading.
+// Returns: error or nil for success.
+func (c *NdjsonFilePersister[T]) LoadEach(ctx context.Context, correlationId string,
+	callback func(item T) error) error {
+	c.corrupt = nil
+
+	file, err := c.file.open(correlationId)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	line := 0
+	for {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return errors.NewFileError(
+				correlationId,
+				"READ_FAILED",
+				"Failed to read data file: "+c.file.path).
+				WithCause(err)
+		}
+		if len(data) == 0 && err == io.EOF {
+			return nil
+		}
+		line++
+
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			item, convErr := c.convertor.FromJson(string(data))
+			if convErr != nil {
+				if !c.skipCorrupt {
+					return errors.NewFileError(
+						correlationId,
+						"CORRUPTED_LINE",
+						"Corrupted line "+strconv.Itoa(line)+" in data file: "+c.file.path).
+						WithDetails("line", line).
+						WithCause(convErr)
+				}
+				c.file.logger.Warn(ctx, correlationId,
+					"Skipped corrupt line %d in data file %s: %v", line, c.file.path, convErr)
+				c.corrupt = append(c.corrupt, line)
+				if c.OnCorruptLine != nil {
+					c.OnCorruptLine(line, convErr)
+				}
+			} else if cbErr := callback(item); cbErr != nil {
+				return cbErr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// Save given data items to external NDJSON file.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+//		- items []T list of data items to save
+//  Returns: error or nil for success.
+func (c *NdjsonFilePersister[T]) Save(ctx context.Context, correlationId string, items []T) error {
+	return c.file.writeWith(correlationId, func(w io.Writer) error {
+		return c.writeItems(correlationId, w, items)
+	})
+}
+
+// Append adds data items to the end of external NDJSON file without rewriting it.
+// With checksum option the whole file is read to verify and update its checksum,
+// so appending to large files is as expensive as loading them.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+//		- items []T list of data items to append
+//  Returns: error or nil for success.
+func (c *NdjsonFilePersister[T]) Append(ctx context.Context, correlationId string, items []T) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	var buffer bytes.Buffer
+	if err := c.writeItems(correlationId, &buffer, items); err != nil {
+		return err
+	}
+
+	return c.file.append(correlationId, buffer.Bytes())
+}
+
+func (c *NdjsonFilePersister[T]) writeItems(correlationId string, w io.Writer, items []T) error {
+	for _, item := range items {
+		json, err := c.convertor.ToJson(item)
+		if err != nil {
+			return errors.NewInternalError(correlationId, "CAN'T_CONVERT", "Failed convert to JSON").
+				WithCause(err)
+		}
+		if _, err = io.WriteString(w, json+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
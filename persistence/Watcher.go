@@ -0,0 +1,290 @@
+package persistence
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pathSource is implemented by loaders that read data from a file or a directory
+type pathSource interface {
+	Path() string
+}
+
+// fileFingerprint describes the state of a data file to detect its changes
+type fileFingerprint struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+	hash    [sha256.Size]byte
+}
+
+// sameStat checks if size and modification time are equal, that is enough to skip hashing
+func (c fileFingerprint) sameStat(other fileFingerprint) bool {
+	return c.exists == other.exists && c.size == other.size && c.modTime.Equal(other.modTime)
+}
+
+// reloadWatcher keeps state of polling for external changes of data file in MemoryPersistence.
+// Items are not reloaded while own changes are not saved yet, so acknowledged writes
+// are never replaced by the file content. Such external changes are overwritten by the next save.
+type reloadWatcher struct {
+	mtx   sync.Mutex
+	known fileFingerprint
+	// saving is a number of writes that released the lock, but are not saved yet
+	saving int
+	// unsaved is true when the last save failed and changes are kept only in memory
+	unsaved   bool
+	listeners []func(ctx context.Context, correlationId string)
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// AddReloadListener adds a listener that is called after items are reloaded
+// because the data file was changed externally.
+//	Parameters:
+//		- listener func(ctx context.Context, correlationId string) a function to call
+func (c *MemoryPersistence[T]) AddReloadListener(listener func(ctx context.Context, correlationId string)) {
+	c.watcher.mtx.Lock()
+	defer c.watcher.mtx.Unlock()
+	c.watcher.listeners = append(c.watcher.listeners, listener)
+}
+
+// watchedPath gets the path of data file when reload on external changes is enabled
+func (c *MemoryPersistence[T]) watchedPath() string {
+	if c.WatchInterval <= 0 {
+		return ""
+	}
+	if source, ok := c.Loader.(pathSource); ok {
+		return source.Path()
+	}
+	return ""
+}
+
+// refreshWatcher remembers the current state of data file after a successful save,
+// so own writes don't trigger reload. Must be called in the same critical section as the write.
+func (c *MemoryPersistence[T]) refreshWatcher() {
+	c.watcher.mtx.Lock()
+	c.watcher.unsaved = false
+	c.watcher.mtx.Unlock()
+
+	path := c.watchedPath()
+	if path == "" {
+		return
+	}
+
+	fingerprint := takeFingerprint(path, fileFingerprint{})
+	c.watcher.mtx.Lock()
+	c.watcher.known = fingerprint
+	c.watcher.mtx.Unlock()
+}
+
+// beginSave marks a write that releases the lock before it is saved.
+// Must be called under write lock.
+func (c *MemoryPersistence[T]) beginSave() {
+	c.watcher.mtx.Lock()
+	c.watcher.saving++
+	c.watcher.mtx.Unlock()
+}
+
+// endSave completes a write marked by beginSave
+func (c *MemoryPersistence[T]) endSave(err error) {
+	c.watcher.mtx.Lock()
+	c.watcher.saving--
+	if err != nil {
+		c.watcher.unsaved = true
+	}
+	c.watcher.mtx.Unlock()
+}
+
+// startWatcher starts polling of data file. Must be called under write lock.
+func (c *MemoryPersistence[T]) startWatcher() {
+	if c.watchedPath() == "" || c.watcher.stop != nil {
+		return
+	}
+	c.refreshWatcher()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	c.watcher.mtx.Lock()
+	c.watcher.stop = stop
+	c.watcher.done = done
+	c.watcher.mtx.Unlock()
+
+	go c.runWatcher(c.WatchInterval, stop, done)
+}
+
+// stopWatcher stops polling and waits until it is finished
+func (c *MemoryPersistence[T]) stopWatcher() {
+	c.watcher.mtx.Lock()
+	stop := c.watcher.stop
+	done := c.watcher.done
+	c.watcher.stop = nil
+	c.watcher.done = nil
+	c.watcher.mtx.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (c *MemoryPersistence[T]) runWatcher(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.checkReload(context.Background(), "")
+		}
+	}
+}
+
+// checkReload reloads items when data file was changed externally.
+// The check is skipped while own changes are not saved. When the data file is removed,
+// loaded items are kept and saved again with the next change.
+func (c *MemoryPersistence[T]) checkReload(ctx context.Context, correlationId string) {
+	// Saves are serialized by flush lock or write lock, so they can't interleave with the check
+	c.writeBehind.flushMtx.Lock()
+	defer c.writeBehind.flushMtx.Unlock()
+
+	c.Mtx.Lock()
+
+	path := c.watchedPath()
+	if path == "" || !c.opened {
+		c.Mtx.Unlock()
+		return
+	}
+
+	c.watcher.mtx.Lock()
+	known := c.watcher.known
+	pending := c.watcher.saving > 0 || c.watcher.unsaved
+	c.watcher.mtx.Unlock()
+
+	c.writeBehind.mtx.Lock()
+	pending = pending || c.writeBehind.dirty > 0
+	c.writeBehind.mtx.Unlock()
+
+	if pending {
+		c.Mtx.Unlock()
+		return
+	}
+
+	fingerprint := takeFingerprint(path, known)
+	if fingerprint == known {
+		c.Mtx.Unlock()
+		return
+	}
+
+	if !fingerprint.exists {
+		c.watcher.mtx.Lock()
+		c.watcher.known = fingerprint
+		c.watcher.mtx.Unlock()
+		c.Mtx.Unlock()
+
+		c.Logger.Warn(ctx, correlationId, "Data file %s was removed, loaded items are kept", path)
+		return
+	}
+
+	items, err := c.Loader.Load(ctx, correlationId)
+	if err != nil && !os.IsNotExist(err) {
+		c.watcher.mtx.Lock()
+		c.watcher.known = fingerprint
+		c.watcher.mtx.Unlock()
+		c.Mtx.Unlock()
+
+		c.Logger.Error(ctx, correlationId, err, "Failed to reload items from changed data file %s", path)
+		return
+	}
+
+	// Loader may rewrite the file, for instance to migrate it, that is not an external change
+	fingerprint = takeFingerprint(path, fingerprint)
+	c.watcher.mtx.Lock()
+	c.watcher.known = fingerprint
+	c.watcher.mtx.Unlock()
+
+	// Writers look up item indexes under the write lock, so replacing items can't redirect their changes
+	c.Items = make([]T, 0, len(items))
+	for _, item := range items {
+		c.Items = append(c.Items, c.cloneItem(item))
+	}
+	c.tracker.reset(len(c.Items))
+	c.changes.reset()
+
+	evicted := c.evictItems(-1)
+	length := len(c.Items)
+	c.Mtx.Unlock()
+
+	c.Logger.Info(ctx, correlationId, "Reloaded %d items from changed data file %s", length, path)
+	c.notifyEvicted(ctx, correlationId, evicted)
+
+	c.watcher.mtx.Lock()
+	listeners := c.watcher.listeners
+	c.watcher.mtx.Unlock()
+	for _, listener := range listeners {
+		listener(ctx, correlationId)
+	}
+}
+
+// takeFingerprint gets the state of a file or a directory.
+// The content is hashed only when size or modification time differ from the known state.
+func takeFingerprint(path string, known fileFingerprint) fileFingerprint {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileFingerprint{}
+	}
+
+	fingerprint := fileFingerprint{exists: true, size: info.Size(), modTime: info.ModTime()}
+	if info.IsDir() {
+		return directoryFingerprint(path, fingerprint)
+	}
+
+	if fingerprint.sameStat(known) {
+		fingerprint.hash = known.hash
+		return fingerprint
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fingerprint
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err == nil {
+		copy(fingerprint.hash[:], hash.Sum(nil))
+	}
+	return fingerprint
+}
+
+// directoryFingerprint hashes names, sizes and modification times of files in the directory
+func directoryFingerprint(path string, fingerprint fileFingerprint) fileFingerprint {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return fingerprint
+	}
+
+	hash := sha256.New()
+	for _, info := range files {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\n", info.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	copy(fingerprint.hash[:], hash.Sum(nil))
+	// Directory entries are compared by the hash only
+	fingerprint.size = 0
+	fingerprint.modTime = time.Time{}
+	return fingerprint
+}
@@ -0,0 +1,165 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// writeBehind keeps state of asynchronous saving in MemoryPersistence.
+// Its flush lock also serializes synchronous saves of collected changes.
+type writeBehind struct {
+	mtx     sync.Mutex
+	dirty   int
+	lastErr error
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+
+	// flushMtx serializes flushes from background and explicit calls
+	flushMtx sync.Mutex
+}
+
+// isWriteBehind checks if changes are saved asynchronously
+func (c *MemoryPersistence[T]) isWriteBehind() bool {
+	return c.FlushInterval > 0 || c.MaxDirtyOperations > 0
+}
+
+// requestSave saves items right away or, in write-behind mode,
+// marks the persistence as dirty and lets the background flusher save them.
+func (c *MemoryPersistence[T]) requestSave(ctx context.Context, correlationId string) error {
+	if !c.isWriteBehind() || c.Saver == nil {
+		c.writeBehind.flushMtx.Lock()
+		defer c.writeBehind.flushMtx.Unlock()
+		return c.saveChanges(ctx, correlationId)
+	}
+
+	c.writeBehind.mtx.Lock()
+	c.writeBehind.dirty++
+	full := c.MaxDirtyOperations > 0 && c.writeBehind.dirty >= c.MaxDirtyOperations
+	wake := c.writeBehind.wake
+	c.writeBehind.mtx.Unlock()
+
+	if full {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush saves pending changes when the persistence works in write-behind mode.
+// Otherwise, it just saves items using configured saver component.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string (optional) transaction id to trace execution through call chain.
+//	Returns: error or nil for success.
+func (c *MemoryPersistence[T]) Flush(ctx context.Context, correlationId string) error {
+	return c.flush(ctx, correlationId, !c.isWriteBehind())
+}
+
+// LastSaveError gets the error of the last save performed in write-behind mode.
+//	Returns: error of the last save or nil when it was successful.
+func (c *MemoryPersistence[T]) LastSaveError() error {
+	c.writeBehind.mtx.Lock()
+	defer c.writeBehind.mtx.Unlock()
+	return c.writeBehind.lastErr
+}
+
+// DirtyOperations gets a number of changes that are not saved yet.
+//	Returns: number of pending changes.
+func (c *MemoryPersistence[T]) DirtyOperations() int {
+	c.writeBehind.mtx.Lock()
+	defer c.writeBehind.mtx.Unlock()
+	return c.writeBehind.dirty
+}
+
+// flush saves items when there are pending changes or when it is forced
+func (c *MemoryPersistence[T]) flush(ctx context.Context, correlationId string, force bool) error {
+	c.writeBehind.flushMtx.Lock()
+	defer c.writeBehind.flushMtx.Unlock()
+
+	c.writeBehind.mtx.Lock()
+	dirty := c.writeBehind.dirty
+	c.writeBehind.dirty = 0
+	c.writeBehind.mtx.Unlock()
+
+	if dirty == 0 && !force {
+		return nil
+	}
+
+	err := c.saveChanges(ctx, correlationId)
+
+	c.writeBehind.mtx.Lock()
+	c.writeBehind.lastErr = err
+	if err != nil {
+		// Keep changes pending to retry them with the next flush
+		c.writeBehind.dirty += dirty
+	}
+	c.writeBehind.mtx.Unlock()
+
+	if err != nil {
+		c.Logger.Error(ctx, correlationId, err, "Failed to save %d pending changes", dirty)
+	}
+	return err
+}
+
+// startWriteBehind starts background flusher. Must be called under write lock.
+func (c *MemoryPersistence[T]) startWriteBehind() {
+	if !c.isWriteBehind() || c.writeBehind.stop != nil {
+		return
+	}
+
+	wake := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	c.writeBehind.mtx.Lock()
+	c.writeBehind.wake = wake
+	c.writeBehind.stop = stop
+	c.writeBehind.done = done
+	c.writeBehind.mtx.Unlock()
+
+	go c.runFlusher(c.FlushInterval, wake, stop, done)
+}
+
+// stopWriteBehind stops background flusher and waits until it is finished
+func (c *MemoryPersistence[T]) stopWriteBehind() {
+	c.writeBehind.mtx.Lock()
+	stop := c.writeBehind.stop
+	done := c.writeBehind.done
+	c.writeBehind.wake = nil
+	c.writeBehind.stop = nil
+	c.writeBehind.done = nil
+	c.writeBehind.mtx.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (c *MemoryPersistence[T]) runFlusher(interval time.Duration,
+	wake <-chan struct{}, stop <-chan struct{}, done chan<- struct{}) {
+
+	defer close(done)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick:
+			_ = c.flush(context.Background(), "", false)
+		case <-wake:
+			_ = c.flush(context.Background(), "", false)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/convert"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	"gopkg.in/yaml.v3"
+)
+
+// YamlFilePersister is a persistence component that loads and saves data from/to flat YAML file.
+// It is used by FilePersistence and IdentifiableFilePersistence the same way as JsonFilePersister.
+// Items are converted through JSON, so they are mapped using "json" struct tags.
+// Data is written into a temporary file, flushed to disk and atomically renamed
+// over the data file, so a crash during Save never leaves a truncated file.
+// Data files of previous versions are upgraded on Load by migrations registered with AddMigration.
+//	Important: this component is not thread save!
+//	Configuration parameters:
+//		- path to the file where data is stored
+//		- options:
+//			- file_mode: octal permissions of the data file (default: "0644")
+//			- backups: number of previous copies kept as "<path>.1", "<path>.2", ... (default: 0)
+//			- compress: true to compress the data file with gzip on save, compressed files are detected on load (default: false)
+//			- encryption_key: AES-256 key to encrypt the data file, see JsonFilePersister
+//			- checksum: true to keep SHA-256 checksum of the data file in "<path>.sha256" and verify it on load (default: false)
+//			- on_corruption: "fail" to return an error or "recover" to load the newest valid backup when the checksum doesn't match (default: "fail")
+//	Typed params:
+//		- T any type
+//	Example:
+//		persister := NewYamlFilePersister[MyData]("./data/data.yaml")
+//		persistence := NewIdentifiableFilePersistence[MyData, string](persister)
+//	Implements: ILoader, ISaver, IConfigurable, IReferenceable
+type YamlFilePersister[T any] struct {
+	file       dataFile
+	convertor  convert.IJSONEngine[[]T]
+	migrations dataMigrations
+}
+
+// NewYamlFilePersister creates a new instance of the persistence.
+//	Typed params:
+//		- T any type
+//	Parameters: path string (optional) a path to the file where data is stored.
+func NewYamlFilePersister[T any](path string) *YamlFilePersister[T] {
+	return &YamlFilePersister[T]{
+		file:      newDataFile(path),
+		convertor: convert.NewDefaultCustomTypeJsonConvertor[[]T](),
+	}
+}
+
+// Path gets the file path where data is stored.
+//	Returns: the file path where data is stored.
+func (c *YamlFilePersister[T]) Path() string {
+	return c.file.path
+}
+
+// SetPath the file path where data is stored.
+//	Parameters:
+//		- value string the file path where data is stored.
+func (c *YamlFilePersister[T]) SetPath(value string) {
+	c.file.path = value
+}
+
+// Configure component by passing configuration parameters.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- config: ConfigParams configuration parameters to be set.
+func (c *YamlFilePersister[T]) Configure(ctx context.Context, config *config.ConfigParams) {
+	c.file.configure(config)
+}
+
+// SetReferences sets references to dependent components.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- references refer.IReferences references to locate the component dependencies.
+func (c *YamlFilePersister[T]) SetReferences(ctx context.Context, references refer.IReferences) {
+	c.file.setReferences(ctx, references)
+}
+
+// Load data items from external YAML file.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+// Returns: []T, error loaded items or error.
+func (c *YamlFilePersister[T]) Load(ctx context.Context, correlationId string) ([]T, error) {
+	data, err := c.file.read(correlationId)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+
+	var value any
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, c.parseError(correlationId, err)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		return nil, c.parseError(correlationId, err)
+	}
+
+	jsonData, migrated, err := c.migrations.unwrap(correlationId, c.file.path, jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := c.convertor.FromJson(string(jsonData))
+	if err != nil {
+		return nil, c.parseError(correlationId, err)
+	}
+
+	if migrated {
+		// Items are already loaded, so a failed rewrite doesn't fail Load
+		if err := c.Save(ctx, correlationId, list); err != nil {
+			c.file.logger.Warn(ctx, correlationId,
+				"Failed to rewrite data file %s in version %d: %v", c.file.path, c.migrations.version, err)
+		}
+	}
+	return list, nil
+}
+
+// AddMigration registers a function that upgrades data items loaded
+// from data files of the previous version to a given version.
+// When migrations are registered, data files are saved in versioned envelope
+// with "version" and "items" properties, see JsonFilePersister.
+//	Parameters:
+//		- version int a version of data items produced by the migration starting from 1
+//		- migration MigrationFunc a function that upgrades data items
+func (c *YamlFilePersister[T]) AddMigration(version int, migration MigrationFunc) {
+	c.migrations.add(version, migration)
+}
+
+// Save given data items to external YAML file.
+//	Parameters:
+//		- ctx context.Context	operation context
+//		- correlationId string transaction id to trace execution through call chain.
+//		- items []T list of data items to save
+//  Returns: error or nil for success.
+func (c *YamlFilePersister[T]) Save(ctx context.Context, correlationId string, items []T) error {
+	if items == nil {
+		items = []T{}
+	}
+	jsonStr, err := c.convertor.ToJson(items)
+	if err != nil {
+		return errors.NewInternalError(correlationId, "CAN'T_CONVERT", "Failed convert to JSON").
+			WithCause(err)
+	}
+	jsonStr = c.migrations.wrap(jsonStr)
+
+	// JSON is a subset of YAML, so the node tree keeps the order of properties
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(jsonStr), &node); err != nil {
+		return errors.NewInternalError(correlationId, "CAN'T_CONVERT", "Failed convert to YAML").
+			WithCause(err)
+	}
+	resetYamlStyle(&node)
+
+	data, err := yaml.Marshal(&node)
+	if err != nil {
+		return errors.NewInternalError(correlationId, "CAN'T_CONVERT", "Failed convert to YAML").
+			WithCause(err)
+	}
+	return c.file.write(correlationId, data)
+}
+
+func (c *YamlFilePersister[T]) parseError(correlationId string, err error) error {
+	return errors.NewFileError(
+		correlationId,
+		"READ_FAILED",
+		"Failed to parse data file: "+c.file.path).
+		WithCause(err)
+}
+
+// resetYamlStyle switches nodes parsed from JSON to the default block style
+func resetYamlStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetYamlStyle(child)
+	}
+}
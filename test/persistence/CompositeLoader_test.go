@@ -0,0 +1,157 @@
+package test_persistence
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cpersist "github.com/pip-services3-gox/pip-services3-data-gox/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallbackLoader(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+	seed := fstest.MapFS{
+		"seed.json": {Data: []byte(`[{"id":"1","key":"Key 1","content":"Seed 1"}]`)},
+	}
+
+	newPersistence := func() *cpersist.IdentifiableFilePersistence[Dummy, string] {
+		persister := cpersist.NewJsonFilePersister[Dummy](filename)
+		persistence := cpersist.NewIdentifiableFilePersistence[Dummy, string](persister)
+		persistence.Loader = cpersist.NewFallbackLoader[Dummy](persister, cpersist.NewFsLoader[Dummy](seed, "seed.json"))
+		return persistence
+	}
+
+	// The first run starts from the seed dataset
+	persistence := newPersistence()
+	err := persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	item, err := persistence.GetOneById(context.Background(), "", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Seed 1", item.Content)
+
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "2", Key: "Key 2", Content: "Content 2"})
+	assert.Nil(t, err)
+	_, err = persistence.DeleteById(context.Background(), "", "1")
+	assert.Nil(t, err)
+	err = persistence.Close(context.Background(), "")
+	assert.Nil(t, err)
+
+	// Next runs use own data file
+	persistence = newPersistence()
+	err = persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer persistence.Close(context.Background(), "")
+
+	items, err := persistence.GetListByIds(context.Background(), "", []string{"1", "2"})
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, "2", items[0].Id)
+}
+
+func TestMergeLoader(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.json":  {Data: []byte(`[{"id":"1","key":"Key 1","content":"Base 1"},{"id":"2","key":"Key 2","content":"Base 2"}]`)},
+		"patch.json": {Data: []byte(`[{"id":"2","key":"Key 2","content":"Patch 2"},{"id":"3","key":"Key 3","content":"Patch 3"}]`)},
+	}
+	base := cpersist.NewFsLoader[Dummy](fsys, "base.json")
+	patch := cpersist.NewFsLoader[Dummy](fsys, "patch.json")
+
+	items, err := cpersist.NewMergeLoader[Dummy](cpersist.LoaderPrecedenceLast, base, patch).
+		Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 3)
+	assert.Equal(t, "Patch 2", items[1].Content)
+
+	items, err = cpersist.NewMergeLoader[Dummy](cpersist.LoaderPrecedenceFirst, base, patch).
+		Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 3)
+	assert.Equal(t, "Base 2", items[1].Content)
+}
+
+func TestFallbackLoaderNoData(t *testing.T) {
+	fsys := fstest.MapFS{
+		"empty.json": {Data: []byte(`[]`)},
+		"seed.json":  {Data: []byte(`[{"id":"1","key":"Key 1","content":"Seed 1"}]`)},
+	}
+
+	// Missing seed files fall back to the next loader
+	items, err := cpersist.NewFallbackLoader[Dummy](
+		cpersist.NewFsLoader[Dummy](fsys, "missing/*.json"),
+		cpersist.NewFsLoader[Dummy](fsys, "seed.json"),
+	).Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, "Seed 1", items[0].Content)
+
+	// Empty list is valid data
+	items, err = cpersist.NewFallbackLoader[Dummy](
+		cpersist.NewFsLoader[Dummy](fsys, "empty.json"),
+		cpersist.NewFsLoader[Dummy](fsys, "seed.json"),
+	).Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.NotNil(t, items)
+	assert.Len(t, items, 0)
+}
+
+func TestFallbackLoaderAllDeleted(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+	seed := fstest.MapFS{
+		"seed.json": {Data: []byte(`[{"id":"1","key":"Key 1","content":"Seed 1"}]`)},
+	}
+
+	newPersistence := func() *cpersist.IdentifiableFilePersistence[Dummy, string] {
+		persister := cpersist.NewJsonFilePersister[Dummy](filename)
+		persistence := cpersist.NewIdentifiableFilePersistence[Dummy, string](persister)
+		persistence.Loader = cpersist.NewFallbackLoader[Dummy](persister, cpersist.NewFsLoader[Dummy](seed, "seed.json"))
+		return persistence
+	}
+
+	persistence := newPersistence()
+	err := persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	_, err = persistence.DeleteById(context.Background(), "", "1")
+	assert.Nil(t, err)
+	err = persistence.Close(context.Background(), "")
+	assert.Nil(t, err)
+
+	// Deleted seed items don't come back
+	persistence = newPersistence()
+	err = persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer persistence.Close(context.Background(), "")
+
+	count, err := persistence.GetCountByFilter(context.Background(), "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestCompositeLoaderInvalidConfig(t *testing.T) {
+	fsys := fstest.MapFS{
+		"seed.json": {Data: []byte(`[{"id":"1","key":"Key 1","content":"Seed 1"}]`)},
+	}
+
+	loader := cpersist.NewFallbackLoader[Dummy](cpersist.NewFsLoader[Dummy](fsys, "seed.json"))
+	loader.Configure(context.Background(), config.NewConfigParamsFromTuples(
+		cpersist.ConfigParamLoaderMode, "merged",
+	))
+	_, err := loader.Load(context.Background(), "")
+	assert.NotNil(t, err)
+	appErr, ok := err.(*errors.ApplicationError)
+	assert.True(t, ok)
+	assert.Equal(t, "INVALID_LOADER_MODE", appErr.Code)
+
+	loader = cpersist.NewMergeLoader[Dummy](cpersist.LoaderPrecedenceFirst, cpersist.NewFsLoader[Dummy](fsys, "seed.json"))
+	loader.Configure(context.Background(), config.NewConfigParamsFromTuples(
+		cpersist.ConfigParamPrecedence, "middle",
+	))
+	_, err = loader.Load(context.Background(), "")
+	assert.NotNil(t, err)
+	appErr, ok = err.(*errors.ApplicationError)
+	assert.True(t, ok)
+	assert.Equal(t, "INVALID_PRECEDENCE", appErr.Code)
+}
@@ -0,0 +1,133 @@
+package test_persistence
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cpersist "github.com/pip-services3-gox/pip-services3-data-gox/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDummyCsvFilePersistence(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.csv")
+
+	persistence := NewDummyFilePersistenceWith(cpersist.NewCsvFilePersister[Dummy](filename))
+	persistence.Configure(context.Background(), cconf.NewEmptyConfigParams())
+
+	defer persistence.Close(context.Background(), "")
+
+	fixture := NewDummyPersistenceFixture(persistence)
+	_ = persistence.Open(context.Background(), "")
+
+	t.Run("DummyCsvFilePersistence:CRUD", fixture.TestCrudOperations)
+	t.Run("DummyCsvFilePersistence:Batch", fixture.TestBatchOperations)
+}
+
+type csvProduct struct {
+	Id      string            `json:"id"`
+	Name    string            `json:"name"`
+	Price   float64           `json:"price"`
+	Stock   int               `json:"stock"`
+	Active  bool              `json:"active"`
+	Created time.Time         `json:"created"`
+	Tags    map[string]string `json:"tags"`
+}
+
+func TestCsvFilePersisterMapping(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "products.csv")
+	err := os.WriteFile(filename, []byte(
+		"Product Id;Product Name;price;stock;active;created;tags\n"+
+			"1;\"Pen; blue\";1.5;10;yes;2022-06-01T10:00:00Z;\"{\"\"color\"\":\"\"blue\"\"}\"\n"+
+			"2;Pencil;0.25;;false;;\n"), 0644)
+	assert.Nil(t, err)
+
+	persister := cpersist.NewCsvFilePersister[csvProduct](filename)
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.delimiter", ";",
+		"mapping.Product Id", "id",
+		"mapping.Product Name", "name",
+	))
+
+	items, err := persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, "Pen; blue", items[0].Name)
+	assert.Equal(t, 1.5, items[0].Price)
+	assert.Equal(t, 10, items[0].Stock)
+	assert.True(t, items[0].Active)
+	assert.Equal(t, time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC), items[0].Created.UTC())
+	assert.Equal(t, "blue", items[0].Tags["color"])
+	assert.Equal(t, "2", items[1].Id)
+	assert.Equal(t, 0, items[1].Stock)
+	assert.False(t, items[1].Active)
+
+	err = persister.Save(context.Background(), "", items)
+	assert.Nil(t, err)
+	saved, err := persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Equal(t, items[0].Name, saved[0].Name)
+	assert.Equal(t, items[0].Tags, saved[0].Tags)
+	assert.Equal(t, items[1].Price, saved[1].Price)
+
+	data, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "Product Id;Product Name;price;stock;active;created;tags\n")
+
+	// Invalid values are reported with the line and the column
+	err = os.WriteFile(filename, []byte(
+		"Product Id;Product Name;price;stock\n"+
+			"1;\"Pen\nwith two lines\";1.5;10\n"+
+			"2;Pencil;cheap;5\n"), 0644)
+	assert.Nil(t, err)
+
+	_, err = persister.Load(context.Background(), "")
+	assert.NotNil(t, err)
+	appErr, ok := err.(*errors.ApplicationError)
+	assert.True(t, ok)
+	assert.Equal(t, "INVALID_VALUE", appErr.Code)
+	assert.Equal(t, 4, appErr.Details["line"])
+	assert.Equal(t, 3, appErr.Details["column"])
+	assert.Equal(t, "price", appErr.Details["column_name"])
+}
+
+func TestCsvFilePersisterWithoutHeader(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.csv")
+	err := os.WriteFile(filename, []byte("1,Key 1,Content 1\n2,Key 2,Content 2\n"), 0644)
+	assert.Nil(t, err)
+
+	persister := cpersist.NewCsvFilePersister[Dummy](filename)
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.header", false,
+	))
+
+	_, err = persister.Load(context.Background(), "")
+	assert.NotNil(t, err)
+
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.columns", "id,key,content",
+	))
+	items, err := persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, "Key 2", items[1].Key)
+
+	// A file without header and columns couldn't be loaded, so it is not saved
+	persister = cpersist.NewCsvFilePersister[Dummy](filename)
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.header", false,
+	))
+	err = persister.Save(context.Background(), "", items)
+	assert.NotNil(t, err)
+	appErr, ok := err.(*errors.ApplicationError)
+	assert.True(t, ok)
+	assert.Equal(t, "NO_COLUMNS", appErr.Code)
+
+	data, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "1,Key 1,Content 1\n2,Key 2,Content 2\n", string(data))
+}
@@ -0,0 +1,98 @@
+package test_persistence
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cpersist "github.com/pip-services3-gox/pip-services3-data-gox/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDummyDirectoryPersistence(t *testing.T) {
+	persistence := NewDummyFilePersistenceWith(cpersist.NewDirectoryPersister[Dummy](""))
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"path", filepath.Join(t.TempDir(), "dummies"),
+	))
+
+	defer persistence.Close(context.Background(), "")
+
+	fixture := NewDummyPersistenceFixture(persistence)
+	_ = persistence.Open(context.Background(), "")
+
+	t.Run("DummyDirectoryPersistence:CRUD", fixture.TestCrudOperations)
+	t.Run("DummyDirectoryPersistence:Batch", fixture.TestBatchOperations)
+}
+
+func TestDirectoryPersisterFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dummies")
+
+	persistence := cpersist.NewIdentifiableFilePersistence[Dummy, string](
+		cpersist.NewDirectoryPersister[Dummy](dir))
+	err := persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "1", Key: "Key 1", Content: "Content 1"})
+	assert.Nil(t, err)
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "a/b", Key: "Key 2", Content: "Content 2"})
+	assert.Nil(t, err)
+
+	info, err := os.Stat(filepath.Join(dir, "1.json"))
+	assert.Nil(t, err)
+	modified := info.ModTime()
+	_, err = os.Stat(filepath.Join(dir, "a%2Fb.json"))
+	assert.Nil(t, err)
+
+	// Only changed files are written
+	err = os.Chtimes(filepath.Join(dir, "1.json"), modified.Add(-3600e9), modified.Add(-3600e9))
+	assert.Nil(t, err)
+	_, err = persistence.Update(context.Background(), "", Dummy{Id: "a/b", Key: "Key 2", Content: "Updated 2"})
+	assert.Nil(t, err)
+	info, err = os.Stat(filepath.Join(dir, "1.json"))
+	assert.Nil(t, err)
+	assert.True(t, info.ModTime().Before(modified))
+
+	_, err = persistence.DeleteById(context.Background(), "", "1")
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, "1.json"))
+	assert.True(t, os.IsNotExist(err))
+
+	err = persistence.Close(context.Background(), "")
+	assert.Nil(t, err)
+
+	// Items are loaded from separate files
+	persistence = cpersist.NewIdentifiableFilePersistence[Dummy, string](
+		cpersist.NewDirectoryPersister[Dummy](dir))
+	err = persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	dummy, err := persistence.GetOneById(context.Background(), "", "a/b")
+	assert.Nil(t, err)
+	assert.Equal(t, "Updated 2", dummy.Content)
+}
+
+func TestDirectoryPersisterFileNames(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dummies")
+	persister := cpersist.NewDirectoryPersister[Dummy](dir)
+
+	// Ids starting with "." are not hidden as temporary files
+	err := persister.Save(context.Background(), "", []Dummy{
+		{Id: ".cfg", Key: "Key 1", Content: "Content 1"},
+		{Id: "..", Key: "Key 2", Content: "Content 2"},
+	})
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, "%2Ecfg.json"))
+	assert.Nil(t, err)
+
+	items, err := cpersist.NewDirectoryPersister[Dummy](dir).Load(context.Background(), "")
+	assert.Nil(t, err)
+	if assert.Len(t, items, 2) {
+		assert.Equal(t, "..", items[0].Id)
+		assert.Equal(t, ".cfg", items[1].Id)
+	}
+
+	// Empty ids are rejected
+	err = persister.Save(context.Background(), "", []Dummy{{Id: "", Key: "Key 3", Content: "Content 3"}})
+	assert.NotNil(t, err)
+}
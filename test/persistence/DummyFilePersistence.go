@@ -8,14 +8,18 @@ import (
 
 type DummyFilePersistence struct {
 	DummyMemoryPersistence
-	persister *cpersist.JsonFilePersister[Dummy]
+	persister cpersist.IFilePersister[Dummy]
 }
 
 func NewDummyFilePersistence(path string) *DummyFilePersistence {
+	return NewDummyFilePersistenceWith(cpersist.NewJsonFilePersister[Dummy](path))
+}
+
+// NewDummyFilePersistenceWith creates the persistence that loads and saves items with the given file persister
+func NewDummyFilePersistenceWith(persister cpersist.IFilePersister[Dummy]) *DummyFilePersistence {
 	c := &DummyFilePersistence{
 		DummyMemoryPersistence: *NewDummyMemoryPersistence(),
 	}
-	persister := cpersist.NewJsonFilePersister[Dummy](path)
 	c.persister = persister
 	c.Loader = persister
 	c.Saver = persister
@@ -3,9 +3,19 @@ package test_persistence
 import (
 	"context"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cdata "github.com/pip-services3-gox/pip-services3-commons-gox/data"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/validate"
+	cpersist "github.com/pip-services3-gox/pip-services3-data-gox/persistence"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDummyFilePersistence(t *testing.T) {
@@ -30,3 +40,506 @@ func TestDummyFilePersistence(t *testing.T) {
 	t.Run("DummyFilePersistence:Batch", fixture.TestBatchOperations)
 
 }
+
+func TestFilePersistenceDefaultPersister(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+
+	// Both nil and a typed nil pointer fall back to JsonFilePersister
+	var typedNil *cpersist.JsonFilePersister[Dummy]
+	for _, persister := range []cpersist.IFilePersister[Dummy]{nil, typedNil} {
+		persistence := cpersist.NewIdentifiableFilePersistence[Dummy, string](persister)
+		assert.NotNil(t, persistence.Persister)
+		_, ok := persistence.Persister.(*cpersist.JsonFilePersister[Dummy])
+		assert.True(t, ok)
+
+		persistence.Persister.SetPath(filename)
+		assert.Equal(t, filename, persistence.Persister.Path())
+
+		err := persistence.Open(context.Background(), "")
+		assert.Nil(t, err)
+		_, err = persistence.Create(context.Background(), "", Dummy{Key: "Key 1", Content: "Content 1"})
+		assert.Nil(t, err)
+		err = persistence.Close(context.Background(), "")
+		assert.Nil(t, err)
+	}
+
+	items, err := cpersist.NewJsonFilePersister[Dummy](filename).Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+}
+
+func TestJsonFilePersisterBackups(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "dummies.json")
+
+	persister := cpersist.NewJsonFilePersister[Dummy](filename)
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.file_mode", "0600",
+		"options.backups", 2,
+	))
+
+	for i := 1; i <= 4; i++ {
+		items := make([]Dummy, i)
+		err := persister.Save(context.Background(), "", items)
+		assert.Nil(t, err)
+	}
+
+	items, err := persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 4)
+
+	info, err := os.Stat(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	backup := cpersist.NewJsonFilePersister[Dummy](filename + ".1")
+	items, err = backup.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 3)
+
+	backup.SetPath(filename + ".2")
+	items, err = backup.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+
+	// Only data file and backups are left, no temporary files
+	files, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 3)
+
+	// A failed write keeps the backups as they are
+	assert.Nil(t, os.Remove(filename))
+	assert.Nil(t, os.MkdirAll(filepath.Join(filename, "locked"), 0755))
+	err = persister.Save(context.Background(), "", make([]Dummy, 5))
+	assert.NotNil(t, err)
+
+	backup.SetPath(filename + ".1")
+	items, err = backup.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 3)
+
+	backup.SetPath(filename + ".2")
+	items, err = backup.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+}
+
+func TestJsonFilePersisterCompression(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+	items := []Dummy{{Id: "1", Key: "Key 1", Content: "Content 1"}}
+
+	persister := cpersist.NewJsonFilePersister[Dummy](filename)
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.compress", true,
+	))
+	err := persister.Save(context.Background(), "", items)
+	assert.Nil(t, err)
+
+	data, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x1f, 0x8b}, data[:2])
+
+	// Compressed files are detected without configuration
+	reader := cpersist.NewJsonFilePersister[Dummy](filename)
+	loaded, err := reader.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Equal(t, items, loaded)
+
+	// Appended items are stored as separate gzip members
+	ndjson := cpersist.NewNdjsonFilePersister[Dummy](filepath.Join(filepath.Dir(filename), "dummies.ndjson"))
+	ndjson.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.compress", true,
+	))
+	err = ndjson.Save(context.Background(), "", items)
+	assert.Nil(t, err)
+	err = ndjson.Append(context.Background(), "", []Dummy{{Id: "2", Key: "Key 2", Content: "Content 2"}})
+	assert.Nil(t, err)
+
+	loaded, err = ndjson.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, loaded, 2)
+	assert.Equal(t, "2", loaded[1].Id)
+}
+
+func TestDummyFilePersistenceReload(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+
+	persistence := NewDummyFilePersistence(filename)
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.watch_interval", 10,
+	))
+	var reloads int32
+	persistence.AddReloadListener(func(ctx context.Context, correlationId string) {
+		atomic.AddInt32(&reloads, 1)
+	})
+
+	err := persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer persistence.Close(context.Background(), "")
+
+	// Own writes don't trigger reload
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "1", Key: "Key 1", Content: "Content 1"})
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&reloads))
+
+	// The file is changed by another process
+	persister := cpersist.NewJsonFilePersister[Dummy](filename)
+	err = persister.Save(context.Background(), "", []Dummy{
+		{Id: "1", Key: "Key 1", Content: "Changed content"},
+		{Id: "2", Key: "Key 2", Content: "Content 2"},
+	})
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&reloads) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	item, err := persistence.GetOneById(context.Background(), "", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Changed content", item.Content)
+
+	count, err := persistence.GetCountByFilter(context.Background(), "", *cdata.NewEmptyFilterParams())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+
+	// Removed file doesn't wipe items
+	err = os.Remove(filename)
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&reloads))
+	count, err = persistence.GetCountByFilter(context.Background(), "", *cdata.NewEmptyFilterParams())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestDummyFilePersistenceReloadPendingChanges(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+
+	persistence := NewDummyFilePersistence(filename)
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.watch_interval", 10,
+		"options.flush_interval", 60000,
+	))
+	var reloads int32
+	persistence.AddReloadListener(func(ctx context.Context, correlationId string) {
+		atomic.AddInt32(&reloads, 1)
+	})
+
+	err := persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer persistence.Close(context.Background(), "")
+
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "1", Key: "Key 1", Content: "Content 1"})
+	assert.Nil(t, err)
+
+	// Acknowledged changes are not replaced by the file until they are saved
+	err = cpersist.NewJsonFilePersister[Dummy](filename).Save(context.Background(), "", []Dummy{
+		{Id: "2", Key: "Key 2", Content: "Content 2"},
+	})
+	assert.Nil(t, err)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&reloads))
+
+	err = persistence.Flush(context.Background(), "")
+	assert.Nil(t, err)
+	items, err := cpersist.NewJsonFilePersister[Dummy](filename).Load(context.Background(), "")
+	assert.Nil(t, err)
+	if assert.Len(t, items, 1) {
+		assert.Equal(t, "1", items[0].Id)
+	}
+}
+
+func TestDummyFilePersistenceReloadMigration(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+
+	persister := cpersist.NewJsonFilePersister[Dummy](filename)
+	persister.AddMigration(1, func(items []map[string]any) ([]map[string]any, error) {
+		return items, nil
+	})
+	persistence := NewDummyFilePersistenceWith(persister)
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.watch_interval", 10,
+	))
+	var reloads int32
+	persistence.AddReloadListener(func(ctx context.Context, correlationId string) {
+		atomic.AddInt32(&reloads, 1)
+	})
+
+	err := persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer persistence.Close(context.Background(), "")
+
+	// The file of the previous version is rewritten on reload, that doesn't trigger another one
+	err = os.WriteFile(filename, []byte(`[{"id":"1","key":"Key 1","content":"Content 1"}]`), 0644)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&reloads) == 1
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&reloads))
+}
+
+// pauseRule is a validation rule that pauses the first validation until it is resumed
+type pauseRule struct {
+	once   sync.Once
+	paused chan struct{}
+	resume chan struct{}
+}
+
+func (c *pauseRule) Validate(path string, schema validate.ISchema, value any) []*validate.ValidationResult {
+	c.once.Do(func() {
+		close(c.paused)
+		<-c.resume
+	})
+	return nil
+}
+
+func TestDummyFilePersistenceReloadDuringUpdate(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+	persister := cpersist.NewJsonFilePersister[Dummy](filename)
+	err := persister.Save(context.Background(), "", []Dummy{
+		{Id: "1", Key: "Key 1", Content: "Content 1"},
+		{Id: "2", Key: "Key 2", Content: "Content 2"},
+		{Id: "3", Key: "Key 3", Content: "Content 3"},
+	})
+	assert.Nil(t, err)
+
+	persistence := NewDummyFilePersistence(filename)
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.watch_interval", 10,
+	))
+	var reloads int32
+	persistence.AddReloadListener(func(ctx context.Context, correlationId string) {
+		atomic.AddInt32(&reloads, 1)
+	})
+	rule := &pauseRule{paused: make(chan struct{}), resume: make(chan struct{})}
+	persistence.Schema = validate.NewSchema().WithRule(rule)
+
+	err = persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer persistence.Close(context.Background(), "")
+
+	// The update is validated before the write lock, meanwhile a reload shifts the items
+	result := make(chan error)
+	go func() {
+		_, err := persistence.Update(context.Background(), "", Dummy{Id: "3", Key: "Key 3", Content: "Updated"})
+		result <- err
+	}()
+	<-rule.paused
+
+	err = persister.Save(context.Background(), "", []Dummy{
+		{Id: "3", Key: "Key 3", Content: "Content 3"},
+	})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&reloads) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	close(rule.resume)
+	assert.Nil(t, <-result)
+
+	items, err := persistence.GetListByFilter(context.Background(), "", nil, nil, nil)
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, "Updated", items[0].Content)
+}
+
+func TestJsonFilePersisterConflict(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+	config := cconf.NewConfigParamsFromTuples(
+		"options.lock", true,
+		"options.lock_timeout", 100,
+	)
+
+	persister1 := cpersist.NewJsonFilePersister[Dummy](filename)
+	persister1.Configure(context.Background(), config)
+	persister2 := cpersist.NewJsonFilePersister[Dummy](filename)
+	persister2.Configure(context.Background(), config)
+
+	_, err := persister1.Load(context.Background(), "")
+	assert.True(t, os.IsNotExist(err))
+	_, err = persister2.Load(context.Background(), "")
+	assert.True(t, os.IsNotExist(err))
+
+	err = persister2.Save(context.Background(), "", []Dummy{{Id: "1", Key: "Key 1", Content: "Content 1"}})
+	assert.Nil(t, err)
+
+	// The first persister doesn't overwrite changes it hasn't seen
+	err = persister1.Save(context.Background(), "", []Dummy{{Id: "2", Key: "Key 2", Content: "Content 2"}})
+	assert.NotNil(t, err)
+	appErr, ok := err.(*cerr.ApplicationError)
+	assert.True(t, ok)
+	assert.Equal(t, cerr.Conflict, appErr.Category)
+	assert.Equal(t, "DATA_FILE_CHANGED", appErr.Code)
+
+	items, err := persister1.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+
+	err = persister1.Save(context.Background(), "", append(items, Dummy{Id: "2", Key: "Key 2", Content: "Content 2"}))
+	assert.Nil(t, err)
+
+	items, err = persister2.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+}
+
+func TestJsonFilePersisterMigrations(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+	err := os.WriteFile(filename, []byte(`[{"id":"1","title":"Key 1","body":"Content 1"}]`), 0644)
+	assert.Nil(t, err)
+
+	rename := func(from string, to string) cpersist.MigrationFunc {
+		return func(items []map[string]any) ([]map[string]any, error) {
+			for _, item := range items {
+				item[to] = item[from]
+				delete(item, from)
+			}
+			return items, nil
+		}
+	}
+
+	persister := cpersist.NewJsonFilePersister[Dummy](filename)
+	persister.AddMigration(1, rename("title", "key"))
+	persister.AddMigration(2, rename("body", "content"))
+	assert.Equal(t, 2, persister.Version())
+
+	items, err := persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Equal(t, []Dummy{{Id: "1", Key: "Key 1", Content: "Content 1"}}, items)
+
+	// The file is rewritten in the latest version
+	data, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"version":2,"items":[{"id":"1","key":"Key 1","content":"Content 1"}]}`, string(data))
+
+	items, err = persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+
+	// Files of newer versions are not loaded
+	err = os.WriteFile(filename, []byte(`{"version":3,"items":[]}`), 0644)
+	assert.Nil(t, err)
+	_, err = persister.Load(context.Background(), "")
+	assert.NotNil(t, err)
+	appErr, ok := err.(*cerr.ApplicationError)
+	assert.True(t, ok)
+	assert.Equal(t, "UNSUPPORTED_VERSION", appErr.Code)
+
+	// With locks the file is rewritten after the shared lock is released
+	err = os.WriteFile(filename, []byte(`[{"id":"1","title":"Key 1","body":"Content 1"}]`), 0644)
+	assert.Nil(t, err)
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.lock", true,
+		"options.lock_timeout", 100,
+	))
+	items, err = persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+	data, err = os.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(data), `{"version":2,`))
+
+	// Own rewrite is not a conflict
+	err = persister.Save(context.Background(), "", items)
+	assert.Nil(t, err)
+}
+
+func TestJsonFilePersisterChecksum(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+
+	persister := cpersist.NewJsonFilePersister[Dummy](filename)
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.checksum", true,
+		"options.backups", 2,
+	))
+
+	for i := 1; i <= 3; i++ {
+		items := make([]Dummy, i)
+		err := persister.Save(context.Background(), "", items)
+		assert.Nil(t, err)
+	}
+	_, err := os.Stat(filename + ".sha256")
+	assert.Nil(t, err)
+	_, err = os.Stat(filename + ".1.sha256")
+	assert.Nil(t, err)
+
+	items, err := persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 3)
+
+	// Damage the data file
+	err = os.WriteFile(filename, []byte(`[{"id":"corrupted"}]`), 0644)
+	assert.Nil(t, err)
+
+	_, err = persister.Load(context.Background(), "")
+	assert.NotNil(t, err)
+	appErr, ok := err.(*cerr.ApplicationError)
+	assert.True(t, ok)
+	assert.Equal(t, "CHECKSUM_MISMATCH", appErr.Code)
+	assert.Equal(t, filename+".1", appErr.Details["backup"])
+
+	// The persistence doesn't open with a corrupted file
+	persistence := cpersist.NewIdentifiableFilePersistence[Dummy, string](persister)
+	err = persistence.Open(context.Background(), "")
+	assert.NotNil(t, err)
+
+	// In recover mode the newest valid backup is loaded
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.on_corruption", "recover",
+	))
+	items, err = persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+}
+
+func TestJsonFilePersisterChecksumInterruptedWrite(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+
+	persister := cpersist.NewJsonFilePersister[Dummy](filename)
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.checksum", true,
+	))
+
+	err := persister.Save(context.Background(), "", make([]Dummy, 1))
+	assert.Nil(t, err)
+	oldData, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	oldSum, err := os.ReadFile(filename + ".sha256")
+	assert.Nil(t, err)
+
+	err = persister.Save(context.Background(), "", make([]Dummy, 2))
+	assert.Nil(t, err)
+	newSum, err := os.ReadFile(filename + ".sha256")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, strings.Count(string(newSum), "\n"))
+
+	// A crash before the data file is replaced leaves the pending checksum
+	// that matches both the previous and the new content
+	pending := strings.TrimSpace(string(newSum)) + "\n" + string(oldSum)
+	err = os.WriteFile(filename+".sha256", []byte(pending), 0644)
+	assert.Nil(t, err)
+	items, err := persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+
+	err = os.WriteFile(filename, oldData, 0644)
+	assert.Nil(t, err)
+	items, err = persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+
+	// Appended content gets a new checksum
+	ndjson := cpersist.NewNdjsonFilePersister[Dummy](filepath.Join(filepath.Dir(filename), "dummies.ndjson"))
+	ndjson.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.checksum", true,
+	))
+	err = ndjson.Save(context.Background(), "", []Dummy{{Id: "1", Key: "Key 1", Content: "Content 1"}})
+	assert.Nil(t, err)
+	err = ndjson.Append(context.Background(), "", []Dummy{{Id: "2", Key: "Key 2", Content: "Content 2"}})
+	assert.Nil(t, err)
+	items, err = ndjson.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+}
@@ -0,0 +1,82 @@
+package test_persistence
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cpersist "github.com/pip-services3-gox/pip-services3-data-gox/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDummyGobFilePersistence(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.gob")
+
+	persistence := NewDummyFilePersistenceWith(cpersist.NewGobFilePersister[Dummy](filename))
+	persistence.Configure(context.Background(), cconf.NewEmptyConfigParams())
+
+	defer persistence.Close(context.Background(), "")
+
+	fixture := NewDummyPersistenceFixture(persistence)
+	_ = persistence.Open(context.Background(), "")
+
+	t.Run("DummyGobFilePersistence:CRUD", fixture.TestCrudOperations)
+	t.Run("DummyGobFilePersistence:Batch", fixture.TestBatchOperations)
+}
+
+func TestGobFilePersisterFormat(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.gob")
+	persister := cpersist.NewGobFilePersister[Dummy](filename)
+
+	items := []Dummy{{Id: "1", Key: "Key 1", Content: "Content 1"}, {Id: "2", Key: "Key 2"}}
+	err := persister.Save(context.Background(), "", items)
+	assert.Nil(t, err)
+
+	loaded, err := persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Equal(t, items, loaded)
+
+	// Files of other formats and newer versions are rejected
+	err = os.WriteFile(filename, []byte("[{\"id\":\"1\"}]"), 0644)
+	assert.Nil(t, err)
+	_, err = persister.Load(context.Background(), "")
+	assert.NotNil(t, err)
+
+	err = os.WriteFile(filename, []byte("PIPGOB\x00\x63\x00\x00\x00\x00\x00\x00\x00\x00"), 0644)
+	assert.Nil(t, err)
+	_, err = persister.Load(context.Background(), "")
+	assert.NotNil(t, err)
+}
+
+func benchmarkItems(count int) []Dummy {
+	items := make([]Dummy, count)
+	for i := range items {
+		id := strconv.Itoa(i)
+		items[i] = Dummy{Id: id, Key: "Key " + id, Content: "Content of the dummy item number " + id}
+	}
+	return items
+}
+
+func benchmarkPersister(b *testing.B, persister cpersist.IFilePersister[Dummy]) {
+	items := benchmarkItems(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := persister.Save(context.Background(), "", items); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := persister.Load(context.Background(), ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkJsonFilePersister(b *testing.B) {
+	benchmarkPersister(b, cpersist.NewJsonFilePersister[Dummy](filepath.Join(b.TempDir(), "dummies.json")))
+}
+
+func BenchmarkGobFilePersister(b *testing.B) {
+	benchmarkPersister(b, cpersist.NewGobFilePersister[Dummy](filepath.Join(b.TempDir(), "dummies.gob")))
+}
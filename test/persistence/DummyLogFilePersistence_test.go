@@ -0,0 +1,87 @@
+package test_persistence
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cdata "github.com/pip-services3-gox/pip-services3-commons-gox/data"
+	cpersist "github.com/pip-services3-gox/pip-services3-data-gox/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDummyLogFilePersistence(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+
+	persistence := NewDummyFilePersistenceWith(cpersist.NewLogFilePersister[Dummy](filename))
+	persistence.Configure(context.Background(), cconf.NewEmptyConfigParams())
+
+	defer persistence.Close(context.Background(), "")
+
+	fixture := NewDummyPersistenceFixture(persistence)
+	_ = persistence.Open(context.Background(), "")
+
+	t.Run("DummyLogFilePersistence:CRUD", fixture.TestCrudOperations)
+	t.Run("DummyLogFilePersistence:Batch", fixture.TestBatchOperations)
+}
+
+func TestDummyLogFilePersistenceReplay(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+	config := cconf.NewConfigParamsFromTuples("options.compact_threshold", 5)
+
+	persistence := NewDummyFilePersistenceWith(cpersist.NewLogFilePersister[Dummy](filename))
+	persistence.Configure(context.Background(), config)
+	_ = persistence.Open(context.Background(), "")
+
+	dummy1, err := persistence.Create(context.Background(), "", Dummy{Key: "Key 1", Content: "Content 1"})
+	assert.Nil(t, err)
+	dummy2, err := persistence.Create(context.Background(), "", Dummy{Key: "Key 2", Content: "Content 2"})
+	assert.Nil(t, err)
+	dummy1.Content = "Updated Content 1"
+	_, err = persistence.Update(context.Background(), "", dummy1)
+	assert.Nil(t, err)
+
+	// Every write appends a single record
+	data, err := os.ReadFile(filename + ".log")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, strings.Count(string(data), "\n"))
+
+	_, err = persistence.DeleteById(context.Background(), "", dummy2.Id)
+	assert.Nil(t, err)
+
+	// Simulate a crash in the middle of append
+	file, err := os.OpenFile(filename+".log", os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, _ = file.WriteString(`{"op":"set","id":"`)
+	_ = file.Close()
+
+	persistence = NewDummyFilePersistenceWith(cpersist.NewLogFilePersister[Dummy](filename))
+	persistence.Configure(context.Background(), config)
+	err = persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+
+	items, err := persistence.GetListByIds(context.Background(), "", []string{dummy1.Id, dummy2.Id})
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, "Updated Content 1", items[0].Content)
+
+	// The fifth record triggers compaction into the snapshot
+	_, err = persistence.Create(context.Background(), "", Dummy{Key: "Key 3", Content: "Content 3"})
+	assert.Nil(t, err)
+
+	data, err = os.ReadFile(filename + ".log")
+	assert.Nil(t, err)
+	assert.Len(t, data, 0)
+
+	persistence = NewDummyFilePersistenceWith(cpersist.NewLogFilePersister[Dummy](filename))
+	persistence.Configure(context.Background(), config)
+	err = persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+
+	page, err := persistence.GetPageByFilter(context.Background(), "", *cdata.NewEmptyFilterParams(), *cdata.NewEmptyPagingParams())
+	assert.Nil(t, err)
+	assert.Len(t, page.Data, 2)
+}
@@ -1,10 +1,23 @@
 package test_persistence
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/convert"
+	cdata "github.com/pip-services3-gox/pip-services3-commons-gox/data"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/validate"
+	cpersist "github.com/pip-services3-gox/pip-services3-data-gox/persistence"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestDummyMemoryPersistence(t *testing.T) {
@@ -17,3 +30,666 @@ func TestDummyMemoryPersistence(t *testing.T) {
 	t.Run("DummyMemoryPersistence:Batch", fixture.TestBatchOperations)
 
 }
+
+func TestDummyMemoryPersistenceEviction(t *testing.T) {
+	persistence := NewDummyMemoryPersistence()
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.max_items", 2,
+		"options.eviction_policy", "lru",
+	))
+
+	evicted := make([]Dummy, 0)
+	persistence.OnEvict = func(ctx context.Context, correlationId string, item Dummy) {
+		evicted = append(evicted, item)
+	}
+
+	dummy1, err := persistence.Create(context.Background(), "", Dummy{Key: "Key 1", Content: "Content 1"})
+	assert.Nil(t, err)
+	dummy2, err := persistence.Create(context.Background(), "", Dummy{Key: "Key 2", Content: "Content 2"})
+	assert.Nil(t, err)
+
+	// Use the first item to make the second one least recently used
+	_, err = persistence.GetOneById(context.Background(), "", dummy1.Id)
+	assert.Nil(t, err)
+
+	dummy3, err := persistence.Create(context.Background(), "", Dummy{Key: "Key 3", Content: "Content 3"})
+	assert.Nil(t, err)
+
+	assert.Len(t, evicted, 1)
+	assert.Equal(t, dummy2.Id, evicted[0].Id)
+
+	items, err := persistence.GetListByIds(context.Background(), "", []string{dummy1.Id, dummy2.Id, dummy3.Id})
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+
+	// Switch to FIFO, the oldest item is evicted regardless of usage
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.eviction_policy", "fifo",
+	))
+	_, err = persistence.Create(context.Background(), "", Dummy{Key: "Key 4", Content: "Content 4"})
+	assert.Nil(t, err)
+
+	assert.Len(t, evicted, 2)
+	assert.Equal(t, dummy1.Id, evicted[1].Id)
+}
+
+func TestDummyMemoryPersistenceEvictionBySize(t *testing.T) {
+	persistence := NewDummyMemoryPersistence()
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.max_bytes", 200,
+		"options.eviction_policy", "lfu",
+	))
+
+	evicted := make([]Dummy, 0)
+	persistence.OnEvict = func(ctx context.Context, correlationId string, item Dummy) {
+		evicted = append(evicted, item)
+	}
+
+	// Every item takes 42 bytes in JSON: {"id":"01","key":"Key","content":"Content"},
+	// so only 4 items fit into 200 bytes and the least used ones are evicted
+	for i := 1; i <= 10; i++ {
+		id := fmt.Sprintf("%02d", i)
+		_, err := persistence.Create(context.Background(), "", Dummy{Id: id, Key: "Key", Content: "Content"})
+		assert.Nil(t, err)
+	}
+
+	count, err := persistence.GetCountByFilter(context.Background(), "", *cdata.NewEmptyFilterParams())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), count)
+
+	assert.Len(t, evicted, 6)
+	for i, item := range evicted {
+		assert.Equal(t, fmt.Sprintf("%02d", i+1), item.Id)
+	}
+	items, err := persistence.GetListByFilter(context.Background(), "", nil, nil, nil)
+	assert.Nil(t, err)
+	for i, item := range items {
+		assert.Equal(t, fmt.Sprintf("%02d", i+7), item.Id)
+	}
+}
+
+func TestDummyMemoryPersistenceEvictionUsage(t *testing.T) {
+	persistence := NewDummyMemoryPersistence()
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.max_items", 3,
+		"options.eviction_policy", "lru",
+	))
+
+	evicted := make([]Dummy, 0)
+	persistence.OnEvict = func(ctx context.Context, correlationId string, item Dummy) {
+		evicted = append(evicted, item)
+	}
+
+	dummy1, _ := persistence.Create(context.Background(), "", Dummy{Key: "Key 1", Content: "Content 1"})
+	dummy2, _ := persistence.Create(context.Background(), "", Dummy{Key: "Key 22", Content: "Content 2"})
+	_, _ = persistence.Create(context.Background(), "", Dummy{Key: "Key 333", Content: "Content 3"})
+
+	// Only the returned item is used, the others scanned by the filter are not
+	page, err := persistence.GetPageByFilter(context.Background(), "",
+		*cdata.NewEmptyFilterParams(), *cdata.NewPagingParams(0, 1, false))
+	assert.Nil(t, err)
+	assert.Len(t, page.Data, 1)
+	assert.Equal(t, dummy1.Id, page.Data[0].Id)
+
+	_, err = persistence.Create(context.Background(), "", Dummy{Key: "Key 4", Content: "Content 4"})
+	assert.Nil(t, err)
+	assert.Len(t, evicted, 1)
+	assert.Equal(t, dummy2.Id, evicted[0].Id)
+
+	// A new item is never evicted by its own creation
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.eviction_policy", "lfu",
+	))
+	for i := 0; i < 3; i++ {
+		_, _ = persistence.GetListByFilter(context.Background(), "", nil, nil, nil)
+		page, _ = persistence.GetPageByFilter(context.Background(), "",
+			*cdata.NewEmptyFilterParams(), *cdata.NewEmptyPagingParams())
+		for _, item := range page.Data {
+			_, _ = persistence.GetOneById(context.Background(), "", item.Id)
+		}
+	}
+	dummy5, err := persistence.Create(context.Background(), "", Dummy{Key: "Key 5", Content: "Content 5"})
+	assert.Nil(t, err)
+	assert.Len(t, evicted, 2)
+	assert.NotEqual(t, dummy5.Id, evicted[1].Id)
+
+	result, err := persistence.GetOneById(context.Background(), "", dummy5.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, dummy5.Id, result.Id)
+
+	// An item that alone exceeds the capacity is rejected
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.max_bytes", 100,
+	))
+	_, err = persistence.Create(context.Background(), "", Dummy{Key: "Key 6", Content: strings.Repeat("Content ", 20)})
+	assert.NotNil(t, err)
+	appErr, ok := err.(*errors.ApplicationError)
+	assert.True(t, ok)
+	assert.Equal(t, "ITEM_TOO_LARGE", appErr.Code)
+}
+
+func TestDummyMemoryPersistenceConcurrentAccess(t *testing.T) {
+	persistence := NewDummyMemoryPersistence()
+	persistence.Configure(context.Background(), cconf.NewEmptyConfigParams())
+
+	// Identifiable writes and inherited reads and deletes must share the same lock,
+	// run with -race to detect unsynchronized access to items
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, _ = persistence.Create(context.Background(), "", Dummy{Key: "Key", Content: "Content"})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, _ = persistence.GetListByFilter(context.Background(), "", nil, nil, nil)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_ = persistence.DeleteByFilter(context.Background(), "", func(item Dummy) bool {
+					return item.Content == "Deleted"
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	count, err := persistence.GetCountByFilter(context.Background(), "", *cdata.NewEmptyFilterParams())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(200), count)
+}
+
+func TestDummyMemoryPersistenceConcurrentEviction(t *testing.T) {
+	persistence := NewDummyMemoryPersistence()
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.max_items", 10,
+		"options.eviction_policy", "fifo",
+	))
+
+	// Creates evict and shift items, so updates and deletes must find them under the write lock
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := "Key " + strconv.Itoa(j)
+				_, _ = persistence.Create(context.Background(), "", Dummy{Key: key, Content: key})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				items, _ := persistence.GetListByFilter(context.Background(), "", nil, nil, nil)
+				for _, item := range items {
+					item.Content = item.Key + " updated"
+					_, _ = persistence.Update(context.Background(), "", item)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				items, _ := persistence.GetListByFilter(context.Background(), "", nil, nil, nil)
+				if len(items) > 0 {
+					_, _ = persistence.DeleteById(context.Background(), "", items[len(items)-1].Id)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	items, err := persistence.GetListByFilter(context.Background(), "", nil, nil, nil)
+	assert.Nil(t, err)
+	ids := make(map[string]bool)
+	for _, item := range items {
+		assert.False(t, ids[item.Id])
+		ids[item.Id] = true
+		assert.True(t, strings.HasPrefix(item.Content, item.Key))
+	}
+}
+
+func TestDummyMemoryPersistenceValidation(t *testing.T) {
+	persistence := NewDummyMemoryPersistence()
+	persistence.Configure(context.Background(), cconf.NewEmptyConfigParams())
+	persistence.Schema = validate.NewObjectSchema().
+		WithRequiredProperty("Key", convert.String).
+		WithRequiredProperty("Content", convert.String, validate.NewValueComparisonRule("NE", ""))
+
+	_, err := persistence.Create(context.Background(), "", Dummy{Key: "Key 1"})
+	assert.NotNil(t, err)
+
+	count, _ := persistence.GetCountByFilter(context.Background(), "", *cdata.NewEmptyFilterParams())
+	assert.Equal(t, int64(0), count)
+
+	dummy, err := persistence.Create(context.Background(), "", Dummy{Key: "Key 1", Content: "Content 1"})
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", dummy.Id)
+
+	_, err = persistence.UpdatePartially(context.Background(), "", dummy.Id,
+		*cdata.NewAnyValueMapFromTuples("Content", ""))
+	assert.NotNil(t, err)
+
+	result, err := persistence.GetOneById(context.Background(), "", dummy.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "Content 1", result.Content)
+}
+
+func TestDummyMemoryPersistenceStrictMode(t *testing.T) {
+	persistence := NewDummyMemoryPersistence()
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.strict", true,
+	))
+
+	_, err := persistence.GetOneById(context.Background(), "123", "missing")
+	assert.NotNil(t, err)
+	appErr, ok := err.(*errors.ApplicationError)
+	assert.True(t, ok)
+	assert.Equal(t, errors.NotFound, appErr.Category)
+	assert.Equal(t, "123", appErr.CorrelationId)
+	assert.Equal(t, "missing", appErr.Details["id"])
+
+	_, err = persistence.Update(context.Background(), "123", Dummy{Id: "missing", Key: "Key 1"})
+	assert.NotNil(t, err)
+
+	_, err = persistence.UpdatePartially(context.Background(), "123", "missing",
+		*cdata.NewAnyValueMapFromTuples("Content", "Content 1"))
+	assert.NotNil(t, err)
+
+	_, err = persistence.DeleteById(context.Background(), "123", "missing")
+	assert.NotNil(t, err)
+
+	dummy, err := persistence.Create(context.Background(), "123", Dummy{Key: "Key 1"})
+	assert.Nil(t, err)
+	_, err = persistence.GetOneById(context.Background(), "123", dummy.Id)
+	assert.Nil(t, err)
+}
+
+func TestDummyMemoryPersistenceModifyById(t *testing.T) {
+	persistence := NewDummyMemoryPersistence()
+	persistence.Configure(context.Background(), cconf.NewEmptyConfigParams())
+
+	dummy, err := persistence.Create(context.Background(), "", Dummy{Key: "Key 1", Content: "0"})
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := persistence.ModifyById(context.Background(), "", dummy.Id,
+				func(item Dummy) (Dummy, error) {
+					item.Content = item.Content + "1"
+					return item, nil
+				})
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	result, err := persistence.GetOneById(context.Background(), "", dummy.Id)
+	assert.Nil(t, err)
+	assert.Len(t, result.Content, 21)
+
+	oldItem, newItem, err := persistence.ModifyById(context.Background(), "", dummy.Id,
+		func(item Dummy) (Dummy, error) {
+			item.Content = "Modified"
+			return item, nil
+		})
+	assert.Nil(t, err)
+	assert.Equal(t, result.Content, oldItem.Content)
+	assert.Equal(t, "Modified", newItem.Content)
+
+	// Failed modifier keeps the item unchanged
+	_, _, err = persistence.ModifyById(context.Background(), "", dummy.Id,
+		func(item Dummy) (Dummy, error) {
+			item.Content = "Failed"
+			return item, errors.NewBadRequestError("", "WRONG_STATE", "Wrong state")
+		})
+	assert.NotNil(t, err)
+
+	result, err = persistence.GetOneById(context.Background(), "", dummy.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "Modified", result.Content)
+
+	// Panicking modifier releases the lock
+	assert.Panics(t, func() {
+		_, _, _ = persistence.ModifyById(context.Background(), "", dummy.Id,
+			func(item Dummy) (Dummy, error) {
+				panic("broken modifier")
+			})
+	})
+	_, err = persistence.Update(context.Background(), "", result)
+	assert.Nil(t, err)
+}
+
+type failingDummySaver struct {
+	fail bool
+}
+
+func (c *failingDummySaver) Save(ctx context.Context, correlationId string, items []Dummy) error {
+	if c.fail {
+		return errors.NewFileError(correlationId, "WRITE_FAILED", "Failed to write data")
+	}
+	return nil
+}
+
+func TestDummyMemoryPersistenceRollback(t *testing.T) {
+	persistence := NewDummyMemoryPersistence()
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.rollback_on_save_error", true,
+	))
+	saver := &failingDummySaver{}
+	persistence.Saver = saver
+
+	dummy, err := persistence.Create(context.Background(), "", Dummy{Key: "Key 1", Content: "Content 1"})
+	assert.Nil(t, err)
+
+	saver.fail = true
+
+	_, err = persistence.Create(context.Background(), "", Dummy{Key: "Key 2", Content: "Content 2"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "CHANGES_NOT_APPLIED", err.(*errors.ApplicationError).Code)
+
+	dummy.Content = "Updated Content 1"
+	_, err = persistence.Update(context.Background(), "", dummy)
+	assert.NotNil(t, err)
+
+	_, err = persistence.DeleteById(context.Background(), "", dummy.Id)
+	assert.NotNil(t, err)
+
+	saver.fail = false
+
+	count, err := persistence.GetCountByFilter(context.Background(), "", *cdata.NewEmptyFilterParams())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+
+	result, err := persistence.GetOneById(context.Background(), "", dummy.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "Content 1", result.Content)
+}
+
+type stubDummyLoader struct {
+	items []Dummy
+	err   error
+}
+
+func (c *stubDummyLoader) Load(ctx context.Context, correlationId string) ([]Dummy, error) {
+	return c.items, c.err
+}
+
+func TestDummyMemoryPersistenceOpenLoadError(t *testing.T) {
+	persistence := NewDummyMemoryPersistence()
+	persistence.Configure(context.Background(), cconf.NewEmptyConfigParams())
+	saver := &countingDummySaver{}
+	loader := &stubDummyLoader{err: os.ErrNotExist}
+	persistence.Loader = loader
+	persistence.Saver = saver
+
+	// Missing data is not an error
+	err := persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "1", Key: "Key 1", Content: "Content 1"})
+	assert.Nil(t, err)
+	err = persistence.Close(context.Background(), "")
+	assert.Nil(t, err)
+	saves, _ := saver.state()
+
+	// Data that can't be read fails to open and is not replaced with empty data
+	loader.err = errors.NewFileError("", "DECRYPTION_FAILED", "Failed to decrypt data file")
+	err = persistence.Open(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Equal(t, "DECRYPTION_FAILED", err.(*errors.ApplicationError).Code)
+
+	count, _ := saver.state()
+	assert.Equal(t, saves, count)
+	total, err := persistence.GetCountByFilter(context.Background(), "", *cdata.NewEmptyFilterParams())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), total)
+}
+
+type countingDummySaver struct {
+	mtx   sync.Mutex
+	saves int
+	items []Dummy
+	err   error
+}
+
+func (c *countingDummySaver) Save(ctx context.Context, correlationId string, items []Dummy) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.saves++
+	c.items = items
+	return nil
+}
+
+func (c *countingDummySaver) state() (int, int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.saves, len(c.items)
+}
+
+func (c *countingDummySaver) setError(err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.err = err
+}
+
+func TestDummyMemoryPersistenceWriteBehind(t *testing.T) {
+	persistence := NewDummyMemoryPersistence()
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.flush_interval", 3600000,
+		"options.max_dirty", 3,
+	))
+	saver := &countingDummySaver{}
+	persistence.Saver = saver
+
+	err := persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err = persistence.Create(context.Background(), "", Dummy{Key: "Key", Content: "Content"})
+		assert.Nil(t, err)
+	}
+	saves, _ := saver.state()
+	assert.Equal(t, 0, saves)
+	assert.Equal(t, 2, persistence.DirtyOperations())
+
+	// The third change reaches the limit and wakes up the flusher
+	_, err = persistence.Create(context.Background(), "", Dummy{Key: "Key", Content: "Content"})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		saves, count := saver.state()
+		return saves == 1 && count == 3
+	}, time.Second, 10*time.Millisecond)
+
+	saver.setError(errors.NewFileError("", "WRITE_FAILED", "Failed to write data"))
+	_, err = persistence.Create(context.Background(), "", Dummy{Key: "Key", Content: "Content"})
+	assert.Nil(t, err)
+	err = persistence.Flush(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.NotNil(t, persistence.LastSaveError())
+	assert.Equal(t, 1, persistence.DirtyOperations())
+
+	saver.setError(nil)
+	err = persistence.Close(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Nil(t, persistence.LastSaveError())
+	assert.Equal(t, 0, persistence.DirtyOperations())
+
+	saves, count := saver.state()
+	assert.Equal(t, 2, saves)
+	assert.Equal(t, 4, count)
+}
+
+type recordingDummySaver struct {
+	saves   int
+	changes []cpersist.ChangeSet[Dummy]
+	err     error
+}
+
+func (c *recordingDummySaver) Save(ctx context.Context, correlationId string, items []Dummy) error {
+	c.saves++
+	return nil
+}
+
+func (c *recordingDummySaver) SaveChanges(ctx context.Context, correlationId string,
+	changes cpersist.ChangeSet[Dummy]) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.changes = append(c.changes, changes)
+	return nil
+}
+
+func TestDummyMemoryPersistenceChangeSaver(t *testing.T) {
+	persistence := NewDummyMemoryPersistence()
+	saver := &recordingDummySaver{}
+	persistence.Saver = saver
+
+	err := persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+
+	dummy1, err := persistence.Create(context.Background(), "", Dummy{Id: "1", Key: "Key 1", Content: "Content 1"})
+	assert.Nil(t, err)
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "2", Key: "Key 2", Content: "Content 2"})
+	assert.Nil(t, err)
+
+	dummy1.Content = "Updated Content 1"
+	_, err = persistence.Update(context.Background(), "", dummy1)
+	assert.Nil(t, err)
+	_, err = persistence.DeleteById(context.Background(), "", "2")
+	assert.Nil(t, err)
+
+	assert.Equal(t, 0, saver.saves)
+	assert.Len(t, saver.changes, 4)
+	assert.Equal(t, "1", saver.changes[0].Inserted[0].Id)
+	assert.Equal(t, "Updated Content 1", saver.changes[2].Updated[0].Content)
+	assert.Equal(t, []any{"2"}, saver.changes[3].Deleted)
+
+	// Failed changes are kept and merged with the next ones
+	saver.err = errors.NewFileError("", "WRITE_FAILED", "Failed to write data")
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "3", Key: "Key 3", Content: "Content 3"})
+	assert.NotNil(t, err)
+
+	saver.err = nil
+	_, err = persistence.Update(context.Background(), "", Dummy{Id: "3", Key: "Key 3", Content: "Updated Content 3"})
+	assert.Nil(t, err)
+	_, err = persistence.DeleteById(context.Background(), "", "1")
+	assert.Nil(t, err)
+
+	assert.Len(t, saver.changes, 6)
+	changes := saver.changes[4]
+	assert.Len(t, changes.Inserted, 1)
+	assert.Equal(t, "Updated Content 3", changes.Inserted[0].Content)
+	assert.Len(t, changes.Updated, 0)
+	assert.Equal(t, []any{"1"}, saver.changes[5].Deleted)
+
+	// Rollback drops only changes of the failed write
+	saver.err = errors.NewFileError("", "WRITE_FAILED", "Failed to write data")
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "4", Key: "Key 4", Content: "Content 4"})
+	assert.NotNil(t, err)
+
+	persistence.RollbackOnSaveError = true
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "5", Key: "Key 5", Content: "Content 5"})
+	assert.NotNil(t, err)
+
+	saver.err = nil
+	dummy6 := Dummy{Id: "6", Key: "Key 6", Content: "Content 6"}
+	created, err := persistence.Create(context.Background(), "", dummy6)
+	assert.Nil(t, err)
+	assert.Equal(t, dummy6, created)
+
+	assert.Len(t, saver.changes, 7)
+	changes = saver.changes[6]
+	assert.Len(t, changes.Inserted, 2)
+	assert.Equal(t, "4", changes.Inserted[0].Id)
+	assert.Equal(t, "6", changes.Inserted[1].Id)
+
+	err = persistence.Close(context.Background(), "")
+	assert.Nil(t, err)
+}
+
+func TestDummyMemoryPersistenceExportImport(t *testing.T) {
+	source := NewDummyMemoryPersistence()
+	_, _ = source.Create(context.Background(), "", Dummy{Id: "1", Key: "Key 1", Content: "Content 1"})
+	_, _ = source.Create(context.Background(), "", Dummy{Id: "2", Key: "Key 2", Content: "Content 2"})
+
+	for _, format := range []cpersist.DataFormat{cpersist.DataFormatJson, cpersist.DataFormatNdjson, cpersist.DataFormatCsv} {
+		var buffer bytes.Buffer
+		err := source.Export(context.Background(), "", &buffer, format)
+		assert.Nil(t, err)
+
+		target := NewDummyMemoryPersistence()
+		report, err := target.Import(context.Background(), "", &buffer, format, cpersist.ImportModeReplace)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, report.Created, format)
+
+		item, err := target.GetOneById(context.Background(), "", "2")
+		assert.Nil(t, err)
+		assert.Equal(t, "Content 2", item.Content, format)
+	}
+
+	persistence := NewDummyMemoryPersistence()
+	_, _ = persistence.Create(context.Background(), "", Dummy{Id: "1", Key: "Key 1", Content: "Content 1"})
+
+	data := `{"id":"1","key":"Key 1","content":"Changed content"}
+{"id":"3","key":"Key 3","content":"Content 3"}
+{"id":"4","key":
+`
+	report, err := persistence.Import(context.Background(), "", strings.NewReader(data),
+		cpersist.DataFormatNdjson, cpersist.ImportModeInsertOnly)
+	assert.Nil(t, err)
+	assert.Equal(t, cpersist.ImportReport{Created: 1, Skipped: 1, Failed: 1, Errors: report.Errors}, report)
+	assert.Len(t, report.Errors, 1)
+
+	item, err := persistence.GetOneById(context.Background(), "", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Content 1", item.Content)
+
+	report, err = persistence.Import(context.Background(), "", strings.NewReader(data),
+		cpersist.DataFormatNdjson, cpersist.ImportModeMerge)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.Updated)
+	assert.Equal(t, 1, report.Failed)
+
+	item, err = persistence.GetOneById(context.Background(), "", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Changed content", item.Content)
+
+	_, err = persistence.Import(context.Background(), "", strings.NewReader("{}"),
+		cpersist.DataFormat("xml"), cpersist.ImportModeMerge)
+	assert.NotNil(t, err)
+
+	// Ids are generated, items are validated and repeated ids are not counted twice
+	persistence = NewDummyMemoryPersistence()
+	persistence.Schema = validate.NewObjectSchema().
+		WithRequiredProperty("Key", convert.String, validate.NewValueComparisonRule("NE", ""))
+	data = `{"key":"Key 1","content":"Content 1"}
+{"id":"2","content":"Content 2"}
+{"id":"3","key":"Key 3","content":"Content 3"}
+{"id":"3","key":"Key 3","content":"Changed content 3"}
+`
+	report, err = persistence.Import(context.Background(), "", strings.NewReader(data),
+		cpersist.DataFormatNdjson, cpersist.ImportModeMerge)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.Created)
+	assert.Equal(t, 0, report.Updated)
+	assert.Equal(t, 2, report.Failed)
+
+	page, err := persistence.GetPageByFilter(context.Background(), "", *cdata.NewEmptyFilterParams(), *cdata.NewEmptyPagingParams())
+	assert.Nil(t, err)
+	if assert.Len(t, page.Data, 2) {
+		assert.NotEqual(t, "", page.Data[0].Id)
+		assert.Equal(t, "Content 3", page.Data[1].Content)
+	}
+}
@@ -0,0 +1,106 @@
+package test_persistence
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cref "github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	clog "github.com/pip-services3-gox/pip-services3-components-gox/log"
+	cpersist "github.com/pip-services3-gox/pip-services3-data-gox/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDummyNdjsonFilePersistence(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.ndjson")
+
+	persistence := NewDummyFilePersistenceWith(cpersist.NewNdjsonFilePersister[Dummy](filename))
+	persistence.Configure(context.Background(), cconf.NewEmptyConfigParams())
+
+	defer persistence.Close(context.Background(), "")
+
+	fixture := NewDummyPersistenceFixture(persistence)
+	_ = persistence.Open(context.Background(), "")
+
+	t.Run("DummyNdjsonFilePersistence:CRUD", fixture.TestCrudOperations)
+	t.Run("DummyNdjsonFilePersistence:Batch", fixture.TestBatchOperations)
+}
+
+func TestNdjsonFilePersisterCorruptLines(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.ndjson")
+	err := os.WriteFile(filename, []byte(
+		"{\"id\":\"1\",\"key\":\"Key 1\",\"content\":\"Content 1\"}\n"+
+			"{\"id\":\"2\",\"key\":\n"+
+			"\n"+
+			"{\"id\":\"3\",\"key\":\"Key 3\",\"content\":\"Content 3\"}\n"+
+			"{\"id\":\"4\",\"ke"), 0644)
+	assert.Nil(t, err)
+
+	persister := cpersist.NewNdjsonFilePersister[Dummy](filename)
+
+	// Corrupt lines fail loading by default
+	_, err = persister.Load(context.Background(), "")
+	assert.NotNil(t, err)
+
+	logger := newCaptureLogger()
+	persister.SetReferences(context.Background(), cref.NewReferencesFromTuples(context.Background(),
+		cref.NewDescriptor("pip-services", "logger", "capture", "default", "1.0"), logger,
+	))
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.skip_corrupt", true,
+	))
+	reported := make([]int, 0)
+	persister.OnCorruptLine = func(line int, err error) {
+		reported = append(reported, line)
+	}
+
+	items, err := persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, "3", items[1].Id)
+	assert.Equal(t, []int{2, 5}, persister.CorruptLines())
+	assert.Equal(t, []int{2, 5}, reported)
+	if assert.Len(t, logger.messages, 2) {
+		assert.Contains(t, logger.messages[0], "line 2")
+		assert.Contains(t, logger.messages[1], "line 5")
+	}
+
+	// Appended items don't merge with the torn last line
+	err = persister.Append(context.Background(), "", []Dummy{{Id: "5", Key: "Key 5", Content: "Content 5"}})
+	assert.Nil(t, err)
+
+	count := 0
+	err = persister.LoadEach(context.Background(), "", func(item Dummy) error {
+		count++
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.skip_corrupt", false,
+	))
+	_, err = persister.Load(context.Background(), "")
+	assert.NotNil(t, err)
+}
+
+// captureLogger keeps warnings written by persisters
+type captureLogger struct {
+	*clog.Logger
+	messages []string
+}
+
+func newCaptureLogger() *captureLogger {
+	c := &captureLogger{}
+	c.Logger = clog.InheritLogger(c)
+	c.SetLevel(clog.LevelTrace)
+	return c
+}
+
+func (c *captureLogger) Write(ctx context.Context, level clog.LevelType, correlationId string, err error, message string) {
+	if level == clog.LevelWarn {
+		c.messages = append(c.messages, message)
+	}
+}
@@ -0,0 +1,60 @@
+package test_persistence
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cpersist "github.com/pip-services3-gox/pip-services3-data-gox/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDummyYamlFilePersistence(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.yaml")
+
+	persistence := NewDummyFilePersistenceWith(cpersist.NewYamlFilePersister[Dummy](filename))
+	persistence.Configure(context.Background(), cconf.NewEmptyConfigParams())
+
+	defer persistence.Close(context.Background(), "")
+
+	fixture := NewDummyPersistenceFixture(persistence)
+	_ = persistence.Open(context.Background(), "")
+
+	t.Run("DummyYamlFilePersistence:CRUD", fixture.TestCrudOperations)
+	t.Run("DummyYamlFilePersistence:Batch", fixture.TestBatchOperations)
+}
+
+func TestYamlFilePersisterFormat(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.yaml")
+	err := os.WriteFile(filename, []byte(
+		"# Seed data\n"+
+			"- id: \"1\"\n"+
+			"  key: Key 1\n"+
+			"  content: Content 1\n"+
+			"- id: \"2\"\n"+
+			"  key: Key 2\n"+
+			"  content: \"123\"\n"), 0644)
+	assert.Nil(t, err)
+
+	persistence := cpersist.NewIdentifiableFilePersistence[Dummy, string](
+		cpersist.NewYamlFilePersister[Dummy](""))
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples("path", filename))
+	err = persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+
+	dummy, err := persistence.GetOneById(context.Background(), "", "2")
+	assert.Nil(t, err)
+	assert.Equal(t, "Key 2", dummy.Key)
+	assert.Equal(t, "123", dummy.Content)
+
+	_, err = persistence.DeleteById(context.Background(), "", "1")
+	assert.Nil(t, err)
+	err = persistence.Close(context.Background(), "")
+	assert.Nil(t, err)
+
+	data, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "- id: \"2\"\n  key: Key 2\n  content: \"123\"\n", string(data))
+}
@@ -0,0 +1,218 @@
+package test_persistence
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	"github.com/pip-services3-gox/pip-services3-commons-gox/refer"
+	"github.com/pip-services3-gox/pip-services3-components-gox/auth"
+	cpersist "github.com/pip-services3-gox/pip-services3-data-gox/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func newEncryptionKey(seed byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{seed}, 32))
+}
+
+func TestFileEncryption(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+	key1 := newEncryptionKey(1)
+	key2 := newEncryptionKey(2)
+
+	persistence := cpersist.NewIdentifiableFilePersistence[Dummy, string](
+		cpersist.NewJsonFilePersister[Dummy](filename))
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.encryption_key", key1,
+	))
+	err := persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "1", Key: "Key 1", Content: "Secret content"})
+	assert.Nil(t, err)
+	err = persistence.Close(context.Background(), "")
+	assert.Nil(t, err)
+
+	data, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(data, []byte("Secret content")))
+
+	// Rotated key reads the old file and re-encrypts it on save
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.encryption_key", key2,
+		"options.old_encryption_keys", key1,
+	))
+	err = persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	dummy, err := persistence.GetOneById(context.Background(), "", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Secret content", dummy.Content)
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "2", Key: "Key 2", Content: "Content 2"})
+	assert.Nil(t, err)
+	err = persistence.Close(context.Background(), "")
+	assert.Nil(t, err)
+
+	// Without the old key the file is still readable with the new one
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.encryption_key", key2,
+		"options.old_encryption_keys", "",
+	))
+	err = persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	err = persistence.Close(context.Background(), "")
+	assert.Nil(t, err)
+
+	// Wrong key fails to open
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.encryption_key", key1,
+	))
+	err = persistence.Open(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Equal(t, "DECRYPTION_FAILED", err.(*errors.ApplicationError).Code)
+
+	// Modified file fails authentication
+	data, err = os.ReadFile(filename)
+	assert.Nil(t, err)
+	data[len(data)-1] ^= 0xff
+	err = os.WriteFile(filename, data, 0644)
+	assert.Nil(t, err)
+
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.encryption_key", key2,
+	))
+	err = persistence.Open(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Equal(t, "DECRYPTION_FAILED", err.(*errors.ApplicationError).Code)
+}
+
+func TestFileEncryptionPlaintext(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+	err := cpersist.NewJsonFilePersister[Dummy](filename).Save(context.Background(), "",
+		[]Dummy{{Id: "1", Key: "Key 1", Content: "Plain content"}})
+	assert.Nil(t, err)
+
+	// Unencrypted file is rejected when the key is set
+	persister := cpersist.NewJsonFilePersister[Dummy](filename)
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.encryption_key", newEncryptionKey(5),
+	))
+	_, err = persister.Load(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Equal(t, "DECRYPTION_FAILED", err.(*errors.ApplicationError).Code)
+
+	// Plaintext is accepted only to migrate existing data
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.allow_plaintext", true,
+	))
+	items, err := persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+	err = persister.Save(context.Background(), "", items)
+	assert.Nil(t, err)
+
+	data, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(data, []byte("Plain content")))
+
+	// Streaming readers reject plaintext as well
+	ndjsonFilename := filepath.Join(t.TempDir(), "dummies.ndjson")
+	err = os.WriteFile(ndjsonFilename, []byte("{\"id\":\"1\",\"key\":\"Key 1\"}\n"), 0644)
+	assert.Nil(t, err)
+	ndjson := cpersist.NewNdjsonFilePersister[Dummy](ndjsonFilename)
+	ndjson.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.encryption_key", newEncryptionKey(5),
+	))
+	_, err = ndjson.Load(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Equal(t, "DECRYPTION_FAILED", err.(*errors.ApplicationError).Code)
+}
+
+func TestFileEncryptionCredential(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+
+	store := auth.NewEmptyMemoryCredentialStore()
+	store.Store(context.Background(), "", "data_key",
+		auth.NewCredentialParamsFromTuples("encryption_key", newEncryptionKey(3)))
+	references := refer.NewReferencesFromTuples(context.Background(),
+		refer.NewDescriptor("pip-services", "credential_store", "memory", "default", "1.0"), store,
+	)
+
+	persister := cpersist.NewJsonFilePersister[Dummy](filename)
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"credential.store_key", "data_key",
+	))
+	persister.SetReferences(context.Background(), references)
+
+	err := persister.Save(context.Background(), "", []Dummy{{Id: "1", Key: "Key 1", Content: "Secret content"}})
+	assert.Nil(t, err)
+
+	data, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(data, []byte("Secret content")))
+
+	// Unencrypted reader can't read the file
+	_, err = cpersist.NewJsonFilePersister[Dummy](filename).Load(context.Background(), "")
+	assert.NotNil(t, err)
+
+	items, err := persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+
+	// Access key of the component's credential is not used as encryption key
+	filename = filepath.Join(t.TempDir(), "plain.json")
+	persister = cpersist.NewJsonFilePersister[Dummy](filename)
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"credential.access_key", newEncryptionKey(5),
+	))
+	err = persister.Save(context.Background(), "", []Dummy{{Id: "1", Key: "Key 1", Content: "Plain content"}})
+	assert.Nil(t, err)
+
+	data, err = os.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Contains(data, []byte("Plain content")))
+}
+
+func TestEncryptedLogFilePersister(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+	config := cconf.NewConfigParamsFromTuples("options.encryption_key", newEncryptionKey(4))
+
+	persistence := NewDummyFilePersistenceWith(cpersist.NewLogFilePersister[Dummy](filename))
+	persistence.Configure(context.Background(), config)
+	err := persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "1", Key: "Key 1", Content: "Secret content"})
+	assert.Nil(t, err)
+	err = persistence.Close(context.Background(), "")
+	assert.Nil(t, err)
+
+	data, err := os.ReadFile(filename + ".log")
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(data, []byte("Secret content")))
+
+	persistence = NewDummyFilePersistenceWith(cpersist.NewLogFilePersister[Dummy](filename))
+	persistence.Configure(context.Background(), config)
+	err = persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	dummy, err := persistence.GetOneById(context.Background(), "", "1")
+	assert.Nil(t, err)
+	assert.Equal(t, "Secret content", dummy.Content)
+	err = persistence.Close(context.Background(), "")
+	assert.Nil(t, err)
+
+	// Unencrypted records in the log are rejected
+	file, err := os.OpenFile(filename+".log", os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteString("{\"op\":\"set\",\"item\":{\"id\":\"2\",\"key\":\"Key 2\"}}\n")
+	assert.Nil(t, err)
+	_ = file.Close()
+
+	persistence = NewDummyFilePersistenceWith(cpersist.NewLogFilePersister[Dummy](filename))
+	persistence.Configure(context.Background(), config)
+	err = persistence.Open(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Equal(t, "DECRYPTION_FAILED", err.(*errors.ApplicationError).Code)
+}
@@ -0,0 +1,47 @@
+package test_persistence
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	cpersist "github.com/pip-services3-gox/pip-services3-data-gox/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFsLoader(t *testing.T) {
+	fsys := fstest.MapFS{
+		"seed/1.json":   {Data: []byte(`[{"id":"1","key":"Key 1","content":"Content 1"}]`)},
+		"seed/2.ndjson": {Data: []byte("{\"id\":\"2\",\"key\":\"Key 2\",\"content\":\"Content 2\"}\n")},
+		"seed/3.yaml":   {Data: []byte("- id: \"3\"\n  key: Key 3\n  content: Content 3\n")},
+		"seed/4.csv":    {Data: []byte("id,key,content\n4,Key 4,Content 4\n")},
+		"bad/1.json":    {Data: []byte(`[{"id":1}]`)},
+	}
+
+	persistence := NewDummyMemoryPersistence()
+	persistence.Loader = cpersist.NewFsLoader[Dummy](fsys, "seed/*")
+
+	err := persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+	defer persistence.Close(context.Background(), "")
+
+	for _, id := range []string{"1", "2", "3", "4"} {
+		item, err := persistence.GetOneById(context.Background(), "", id)
+		assert.Nil(t, err)
+		assert.Equal(t, "Key "+id, item.Key)
+	}
+
+	// Writes stay in memory
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "5", Key: "Key 5", Content: "Content 5"})
+	assert.Nil(t, err)
+	_, err = fsys.Open("seed/5.json")
+	assert.NotNil(t, err)
+
+	loader := cpersist.NewFsLoader[Dummy](fsys, "bad/*.json")
+	_, err = loader.Load(context.Background(), "")
+	assert.NotNil(t, err)
+
+	loader = cpersist.NewFsLoader[Dummy](fsys, "missing/*.json")
+	_, err = loader.Load(context.Background(), "")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,230 @@
+package test_persistence
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cpersist "github.com/pip-services3-gox/pip-services3-data-gox/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+// datasetServer is a file service that keeps a single JSON dataset with ETags
+type datasetServer struct {
+	mtx       sync.Mutex
+	data      []byte
+	version   int
+	gets      int
+	notMod    int
+	failures  int
+	throttled int
+	// retryAfter is Retry-After header of throttled responses, "0" when it is not set
+	retryAfter string
+	lastMatch  string
+}
+
+func (c *datasetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.failures > 0 {
+		c.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if c.throttled > 0 {
+		c.throttled--
+		retryAfter := c.retryAfter
+		if retryAfter == "" {
+			retryAfter = "0"
+		}
+		w.Header().Set("Retry-After", retryAfter)
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	etag := "\"" + strconv.Itoa(c.version) + "\""
+	switch r.Method {
+	case http.MethodGet:
+		c.gets++
+		if c.data == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			c.notMod++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = w.Write(c.data)
+	case http.MethodPut:
+		c.lastMatch = r.Header.Get("If-Match")
+		if c.data != nil && c.lastMatch != etag || c.data == nil && c.lastMatch != "" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		c.data, _ = io.ReadAll(r.Body)
+		c.version++
+		w.Header().Set("ETag", "\""+strconv.Itoa(c.version)+"\"")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (c *datasetServer) change(data string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.data = []byte(data)
+	c.version++
+}
+
+func TestHttpPersister(t *testing.T) {
+	dataset := &datasetServer{}
+	server := httptest.NewServer(dataset)
+	defer server.Close()
+
+	persister := cpersist.NewHttpPersister[Dummy]("")
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"url", server.URL+"/dummies.json",
+		"options.retries", 2,
+		"options.retry_timeout", 1,
+	))
+
+	// Missing dataset opens empty
+	persistence := cpersist.NewIdentifiableMemoryPersistence[Dummy, string]()
+	persistence.Loader = persister
+	persistence.Saver = persister
+	err := persistence.Open(context.Background(), "")
+	assert.Nil(t, err)
+
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "1", Key: "Key 1", Content: "Content 1"})
+	assert.Nil(t, err)
+	assert.Equal(t, `[{"id":"1","key":"Key 1","content":"Content 1"}]`, string(dataset.data))
+
+	// Unchanged data is not transferred again
+	items, err := persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, 1, dataset.notMod)
+
+	// Failed requests are retried
+	dataset.failures = 2
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "2", Key: "Key 2", Content: "Content 2"})
+	assert.Nil(t, err)
+	assert.Equal(t, "\"1\"", dataset.lastMatch)
+
+	// Remote changes are not overwritten
+	dataset.change(`[{"id":"3","key":"Key 3","content":"Content 3"}]`)
+	_, err = persistence.Create(context.Background(), "", Dummy{Id: "4", Key: "Key 4", Content: "Content 4"})
+	assert.NotNil(t, err)
+	appErr, ok := err.(*cerr.ApplicationError)
+	assert.True(t, ok)
+	assert.Equal(t, "DATA_CHANGED", appErr.Code)
+
+	items, err = persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 1)
+	assert.Equal(t, "3", items[0].Id)
+
+	dataset.failures = 3
+	_, err = persister.Load(context.Background(), "")
+	assert.NotNil(t, err)
+}
+
+// lostResponseTransport sends requests but loses the response to the first PUT
+type lostResponseTransport struct {
+	lost bool
+}
+
+func (c *lostResponseTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := http.DefaultTransport.RoundTrip(request)
+	if err == nil && request.Method == http.MethodPut && !c.lost {
+		c.lost = true
+		_ = response.Body.Close()
+		return nil, io.ErrUnexpectedEOF
+	}
+	return response, err
+}
+
+func TestHttpPersisterLostResponse(t *testing.T) {
+	dataset := &datasetServer{}
+	server := httptest.NewServer(dataset)
+	defer server.Close()
+
+	persister := cpersist.NewHttpPersister[Dummy](server.URL + "/dummies.json")
+	persister.Client = &http.Client{Transport: &lostResponseTransport{}}
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.retry_timeout", 1,
+	))
+
+	// The first attempt creates the dataset, the retry is rejected but the data is there
+	err := persister.Save(context.Background(), "", []Dummy{{Id: "1", Key: "Key 1", Content: "Content 1"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, dataset.version)
+
+	// The known ETag is used for the next save
+	err = persister.Save(context.Background(), "", []Dummy{{Id: "2", Key: "Key 2", Content: "Content 2"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "\"1\"", dataset.lastMatch)
+}
+
+func TestHttpPersisterTimeoutAndRetryAfter(t *testing.T) {
+	dataset := &datasetServer{data: []byte(`[]`)}
+	slow := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.json" {
+			select {
+			case <-slow:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		dataset.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	defer close(slow)
+
+	// Shared client is not changed by configuration and nil client is allowed
+	client := &http.Client{}
+	persister := cpersist.NewHttpPersister[Dummy](server.URL + "/slow.json")
+	persister.Client = client
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.timeout", 50,
+		"options.retries", 0,
+	))
+	assert.Equal(t, time.Duration(0), client.Timeout)
+
+	_, err := persister.Load(context.Background(), "")
+	assert.NotNil(t, err)
+
+	// Retry-After overrides the backoff pause
+	persister = cpersist.NewHttpPersister[Dummy](server.URL + "/dummies.json")
+	persister.Client = nil
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.retry_timeout", 60000,
+	))
+	dataset.throttled = 1
+	start := time.Now()
+	items, err := persister.Load(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, items, 0)
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	// Pause longer than the timeout is not waited for
+	dataset.throttled = 1
+	dataset.retryAfter = "3600"
+	start = time.Now()
+	_, err = persister.Load(context.Background(), "")
+	assert.NotNil(t, err)
+	appErr, ok := err.(*cerr.ApplicationError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusTooManyRequests, appErr.Details["status"])
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
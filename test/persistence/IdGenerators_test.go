@@ -0,0 +1,113 @@
+package test_persistence
+
+import (
+	"context"
+	"math"
+	"os"
+	"regexp"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cpersist "github.com/pip-services3-gox/pip-services3-data-gox/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+type DummySeq struct {
+	Id      int64  `json:"id"`
+	Key     string `json:"key"`
+	Content string `json:"content"`
+}
+
+func TestStringIdGenerators(t *testing.T) {
+	formats := map[string]*regexp.Regexp{
+		"long":   regexp.MustCompile(`^[0-9a-f]{32}$`),
+		"uuid":   regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		"uuidv7": regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		"ulid":   regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`),
+	}
+
+	for name, format := range formats {
+		persistence := NewDummyMemoryPersistence()
+		persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+			"options.id_generator", name,
+		))
+
+		dummy1, err := persistence.Create(context.Background(), "", Dummy{Key: "Key 1"})
+		assert.Nil(t, err)
+		dummy2, err := persistence.Create(context.Background(), "", Dummy{Key: "Key 2"})
+		assert.Nil(t, err)
+
+		assert.Regexp(t, format, dummy1.Id, name)
+		assert.NotEqual(t, dummy1.Id, dummy2.Id)
+	}
+
+	generator := cpersist.NewUlidIdGenerator[string]()
+	id1 := generator.NextId()
+	id2 := generator.NextId()
+	assert.True(t, id1 < id2)
+
+	_, err := cpersist.NewIdGenerator[string]("sequence")
+	assert.NotNil(t, err)
+
+	// Unknown generator fails to open the persistence
+	persistence := NewDummyMemoryPersistence()
+	persistence.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.id_generator", "unknown",
+	))
+	err = persistence.Open(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.False(t, persistence.IsOpen())
+}
+
+func TestSequenceIdGenerator(t *testing.T) {
+	filename := "../../data/dummies_seq.json"
+	defer os.Remove(filename)
+
+	persistence := cpersist.NewIdentifiableFilePersistence[DummySeq, int64](
+		cpersist.NewJsonFilePersister[DummySeq](filename))
+	_ = persistence.Open(context.Background(), "")
+
+	dummy1, err := persistence.Create(context.Background(), "", DummySeq{Key: "Key 1"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), dummy1.Id)
+
+	dummy2, err := persistence.Create(context.Background(), "", DummySeq{Key: "Key 2"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), dummy2.Id)
+
+	err = persistence.Close(context.Background(), "")
+	assert.Nil(t, err)
+
+	// Reopened persistence continues the sequence
+	persistence = cpersist.NewIdentifiableFilePersistence[DummySeq, int64](
+		cpersist.NewJsonFilePersister[DummySeq](filename))
+	_ = persistence.Open(context.Background(), "")
+	defer persistence.Close(context.Background(), "")
+
+	dummy3, err := persistence.Create(context.Background(), "", DummySeq{Key: "Key 3"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), dummy3.Id)
+
+	result, err := persistence.GetOneById(context.Background(), "", 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "Key 3", result.Key)
+
+	// Explicit ids of created and set items are never generated again
+	_, err = persistence.Create(context.Background(), "", DummySeq{Id: 5, Key: "Key 5"})
+	assert.Nil(t, err)
+	dummy6, err := persistence.Create(context.Background(), "", DummySeq{Key: "Key 6"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), dummy6.Id)
+
+	_, err = persistence.Set(context.Background(), "", DummySeq{Id: 10, Key: "Key 10"})
+	assert.Nil(t, err)
+	dummy11, err := persistence.Create(context.Background(), "", DummySeq{Key: "Key 11"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), dummy11.Id)
+
+	// Unsigned ids beyond int64 range don't break the sequence
+	generator := cpersist.NewSequenceIdGenerator[uint64](0)
+	generator.Observe(math.MaxUint64)
+	generator.Observe(10)
+	assert.Equal(t, uint64(11), generator.NextId())
+}
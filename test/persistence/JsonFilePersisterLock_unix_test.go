@@ -0,0 +1,99 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package test_persistence
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+
+	cconf "github.com/pip-services3-gox/pip-services3-commons-gox/config"
+	cerr "github.com/pip-services3-gox/pip-services3-commons-gox/errors"
+	cpersist "github.com/pip-services3-gox/pip-services3-data-gox/persistence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJsonFilePersisterLockTimeout(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+
+	persister := cpersist.NewJsonFilePersister[Dummy](filename)
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.lock", true,
+		"options.lock_timeout", 50,
+	))
+
+	// Another process holds a shared lock
+	lockFile, err := os.OpenFile(filename+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	assert.Nil(t, err)
+	defer lockFile.Close()
+	err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_SH)
+	assert.Nil(t, err)
+
+	// Readers share the lock
+	_, err = persister.Load(context.Background(), "")
+	assert.True(t, os.IsNotExist(err))
+
+	// Writers wait for the exclusive lock
+	err = persister.Save(context.Background(), "", []Dummy{{Id: "1", Key: "Key 1", Content: "Content 1"}})
+	assert.NotNil(t, err)
+	appErr, ok := err.(*cerr.ApplicationError)
+	assert.True(t, ok)
+	assert.Equal(t, "FILE_LOCKED", appErr.Code)
+
+	err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+	assert.Nil(t, err)
+
+	err = persister.Save(context.Background(), "", []Dummy{{Id: "1", Key: "Key 1", Content: "Content 1"}})
+	assert.Nil(t, err)
+}
+
+func TestJsonFilePersisterLockFileMode(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+
+	persister := cpersist.NewJsonFilePersister[Dummy](filename)
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.lock", true,
+		"options.file_mode", "0600",
+	))
+	err := persister.Save(context.Background(), "", []Dummy{{Id: "1", Key: "Key 1", Content: "Content 1"}})
+	assert.Nil(t, err)
+
+	// The lock file is not more accessible than the data file
+	info, err := os.Stat(filename + ".lock")
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestJsonFilePersisterLockConcurrentSaves(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dummies.json")
+
+	persister := cpersist.NewJsonFilePersister[Dummy](filename)
+	persister.Configure(context.Background(), cconf.NewConfigParamsFromTuples(
+		"options.lock", true,
+		"options.lock_timeout", 5000,
+	))
+
+	// Concurrent saves of the same persister hold own locks and release only them
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				err := persister.Save(context.Background(), "", []Dummy{{Id: "1", Key: "Key 1", Content: "Content 1"}})
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// All locks are released
+	lockFile, err := os.OpenFile(filename+".lock", os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	defer lockFile.Close()
+	err = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	assert.Nil(t, err)
+}